@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	Catalog struct {
+		Scopes    Scopes    `yaml:"scopes"`
+		Audiences Audiences `yaml:"audiences"`
+	}
+
+	Scope struct {
+		Name        string `yaml:"name"`
+		Title       string `yaml:"title"`
+		Description string `yaml:"description"`
+		Group       string `yaml:"group"`
+		Sensitive   bool   `yaml:"sensitive"`
+		Required    bool   `yaml:"required"`
+	}
+
+	Scopes []Scope
+
+	Audience struct {
+		ID   string `yaml:"id"`
+		Name string `yaml:"name"`
+	}
+
+	Audiences []Audience
+)
+
+var (
+	ErrScopeNameMissing    = errors.New("scope name is missing")
+	ErrScopeTitleMissing   = errors.New("scope title is missing")
+	ErrScopeDuplicate      = errors.New("scope is listed more than once")
+	ErrAudienceIDMissing   = errors.New("audience id is missing")
+	ErrAudienceNameMissing = errors.New("audience name is missing")
+	ErrAudienceDuplicate   = errors.New("audience is listed more than once")
+)
+
+// Load reads the catalog from a YAML file. JSON, being
+// a subset of YAML, is accepted as well.
+func Load(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var c Catalog
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode catalog: %w", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *Catalog) Validate() error {
+	if err := c.Scopes.validate(); err != nil {
+		return err
+	}
+
+	return c.Audiences.validate()
+}
+
+func (s Scopes) validate() error {
+	seen := make(map[string]bool, len(s))
+
+	for i := range s {
+		switch {
+		case s[i].Name == "":
+			return fmt.Errorf("scope %d: %w", i, ErrScopeNameMissing)
+		case s[i].Title == "":
+			return fmt.Errorf("scope %d: %w", i, ErrScopeTitleMissing)
+		case seen[s[i].Name]:
+			return fmt.Errorf("scope %d: %s: %w", i, s[i].Name, ErrScopeDuplicate)
+		}
+
+		seen[s[i].Name] = true
+	}
+
+	return nil
+}
+
+// Lookup returns the position of the scope in the
+// catalog and its description, if it is known.
+func (s Scopes) Lookup(name string) (int, *Scope, bool) {
+	for i := range s {
+		if s[i].Name == name {
+			return i, &s[i], true
+		}
+	}
+
+	return -1, nil, false
+}
+
+func (a Audiences) validate() error {
+	seen := make(map[string]bool, len(a))
+
+	for i := range a {
+		switch {
+		case a[i].ID == "":
+			return fmt.Errorf("audience %d: %w", i, ErrAudienceIDMissing)
+		case a[i].Name == "":
+			return fmt.Errorf("audience %d: %w", i, ErrAudienceNameMissing)
+		case seen[a[i].ID]:
+			return fmt.Errorf("audience %d: %s: %w", i, a[i].ID, ErrAudienceDuplicate)
+		}
+
+		seen[a[i].ID] = true
+	}
+
+	return nil
+}
+
+// Name returns the human-readable name of the audience,
+// falling back to its identifier for unknown ones.
+func (a Audiences) Name(id string) string {
+	for i := range a {
+		if a[i].ID == id {
+			return a[i].Name
+		}
+	}
+
+	return id
+}
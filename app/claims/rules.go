@@ -0,0 +1,179 @@
+package claims
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Rule maps a trait onto a claim of one of the tokens. A rule
+	// without a scope is applied whatever scopes are granted, and
+	// one without clients is applied for every client.
+	Rule struct {
+		Trait   string   `yaml:"trait"`
+		Claim   string   `yaml:"claim"`
+		Token   Token    `yaml:"token"`
+		Scope   string   `yaml:"scope"`
+		Clients []string `yaml:"clients"`
+	}
+
+	Rules []Rule
+
+	Token string
+
+	Claims = map[string]interface{}
+)
+
+const (
+	IDToken     Token = "id_token"
+	AccessToken Token = "access_token"
+)
+
+var (
+	ErrTraitMissing   = errors.New("trait is missing")
+	ErrTraitUnknown   = errors.New("trait is unknown")
+	ErrClaimMissing   = errors.New("claim is missing")
+	ErrClaimReserved  = errors.New("claim is reserved")
+	ErrClaimDuplicate = errors.New("claim is mapped more than once")
+	ErrTokenInvalid   = errors.New("token must be either id_token or access_token")
+)
+
+// reserved claims are set by Hydra and can not be overridden.
+var reserved = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "iat": true, "nbf": true,
+	"jti": true, "auth_time": true, "nonce": true, "acr": true, "amr": true,
+	"azp": true, "at_hash": true, "c_hash": true, "sid": true,
+	"scp": true, "client_id": true, "ext": true,
+}
+
+// traits are the names of the identity traits the rules may map.
+var traits = map[string]bool{
+	"email": true, "email_verified": true, "name": true, "given_name": true,
+	"family_name": true, "picture": true, "locale": true, "phone_number": true,
+	"phone_number_verified": true, "groups": true,
+}
+
+// Load reads the rules from a YAML file. JSON, being
+// a subset of YAML, is accepted as well.
+func Load(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim rules: %w", err)
+	}
+
+	var file struct {
+		Rules Rules `yaml:"rules"`
+	}
+
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode claim rules: %w", err)
+	}
+
+	if err := file.Rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	return file.Rules, nil
+}
+
+func (r Rules) Validate() error {
+	type target struct {
+		claim   string
+		token   Token
+		scope   string
+		clients string
+	}
+
+	seen := make(map[target]bool, len(r))
+
+	for i := range r {
+		rule := &r[i]
+
+		switch {
+		case rule.Trait == "":
+			return fmt.Errorf("rule %d: %w", i, ErrTraitMissing)
+		case !traits[rule.Trait]:
+			return fmt.Errorf("rule %d: %s: %w", i, rule.Trait, ErrTraitUnknown)
+		case rule.Claim == "":
+			return fmt.Errorf("rule %d: %w", i, ErrClaimMissing)
+		case reserved[rule.Claim]:
+			return fmt.Errorf("rule %d: %s: %w", i, rule.Claim, ErrClaimReserved)
+		case rule.Token != IDToken && rule.Token != AccessToken:
+			return fmt.Errorf("rule %d: %w", i, ErrTokenInvalid)
+		}
+
+		// The same clients listed in another order are the same target.
+		clients := append([]string(nil), rule.Clients...)
+		sort.Strings(clients)
+
+		t := target{
+			claim:   rule.Claim,
+			token:   rule.Token,
+			scope:   rule.Scope,
+			clients: strings.Join(clients, ","),
+		}
+
+		if seen[t] {
+			return fmt.Errorf("rule %d: %s: %w", i, rule.Claim, ErrClaimDuplicate)
+		}
+
+		seen[t] = true
+	}
+
+	return nil
+}
+
+// Apply maps the traits onto the ID token and access token claims
+// according to the rules matching the client and granted scopes.
+func (r Rules) Apply(traits Claims, clientID string, grantScope []string) (idToken, accessToken Claims) {
+	idToken, accessToken = make(Claims), make(Claims)
+
+	for i := range r {
+		rule := &r[i]
+
+		if !rule.matches(clientID, grantScope) {
+			continue
+		}
+
+		v, ok := traits[rule.Trait]
+		if !ok {
+			continue
+		}
+
+		switch rule.Token {
+		case IDToken:
+			idToken[rule.Claim] = v
+		case AccessToken:
+			accessToken[rule.Claim] = v
+		}
+	}
+
+	return idToken, accessToken
+}
+
+func (r *Rule) matches(clientID string, grantScope []string) bool {
+	if r.Scope != "" && !contains(grantScope, r.Scope) {
+		return false
+	}
+
+	if len(r.Clients) != 0 && !contains(r.Clients, clientID) {
+		return false
+	}
+
+	return true
+}
+
+func contains(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
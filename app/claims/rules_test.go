@@ -0,0 +1,159 @@
+package claims
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules Rules
+		err   error
+	}{
+		{
+			name: "valid",
+			rules: Rules{
+				{Trait: "groups", Claim: "groups", Token: IDToken, Scope: "groups"},
+				{Trait: "groups", Claim: "groups", Token: AccessToken, Scope: "groups"},
+				{Trait: "groups", Claim: "groups", Token: IDToken, Scope: "roles"},
+				{Trait: "groups", Claim: "groups", Token: IDToken, Scope: "groups", Clients: []string{"app"}},
+			},
+		},
+		{
+			name:  "no rules",
+			rules: Rules{},
+		},
+		{
+			name:  "trait missing",
+			rules: Rules{{Claim: "groups", Token: IDToken}},
+			err:   ErrTraitMissing,
+		},
+		{
+			name:  "trait unknown",
+			rules: Rules{{Trait: "password", Claim: "password", Token: IDToken}},
+			err:   ErrTraitUnknown,
+		},
+		{
+			name:  "claim missing",
+			rules: Rules{{Trait: "groups", Token: IDToken}},
+			err:   ErrClaimMissing,
+		},
+		{
+			name:  "claim reserved",
+			rules: Rules{{Trait: "email", Claim: "sub", Token: IDToken}},
+			err:   ErrClaimReserved,
+		},
+		{
+			name:  "token missing",
+			rules: Rules{{Trait: "groups", Claim: "groups"}},
+			err:   ErrTokenInvalid,
+		},
+		{
+			name:  "token invalid",
+			rules: Rules{{Trait: "groups", Claim: "groups", Token: "refresh_token"}},
+			err:   ErrTokenInvalid,
+		},
+		{
+			name: "duplicate",
+			rules: Rules{
+				{Trait: "groups", Claim: "roles", Token: IDToken},
+				{Trait: "email", Claim: "roles", Token: IDToken},
+			},
+			err: ErrClaimDuplicate,
+		},
+		{
+			name: "duplicate for the same clients in another order",
+			rules: Rules{
+				{Trait: "groups", Claim: "roles", Token: IDToken, Clients: []string{"a", "b"}},
+				{Trait: "email", Claim: "roles", Token: IDToken, Clients: []string{"b", "a"}},
+			},
+			err: ErrClaimDuplicate,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.rules.Validate()
+
+			if !errors.Is(err, c.err) || (c.err == nil && err != nil) {
+				t.Fatalf("validation failed with %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateKeepsClientOrder(t *testing.T) {
+	rules := Rules{{Trait: "groups", Claim: "groups", Token: IDToken, Clients: []string{"b", "a"}}}
+
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rules[0].Clients; !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Fatalf("clients = %v", got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var (
+		traits = Claims{"email": "jdoe@example.com", "groups": []string{"staff"}}
+		rules  = Rules{
+			{Trait: "email", Claim: "mail", Token: AccessToken},
+			{Trait: "groups", Claim: "groups", Token: IDToken, Scope: "groups"},
+			{Trait: "groups", Claim: "roles", Token: AccessToken, Clients: []string{"partner", "app"}},
+			{Trait: "name", Claim: "display_name", Token: IDToken},
+		}
+	)
+
+	cases := []struct {
+		name        string
+		clientID    string
+		grantScope  []string
+		idToken     Claims
+		accessToken Claims
+	}{
+		{
+			name:        "rules without a scope applied unconditionally",
+			clientID:    "other",
+			idToken:     Claims{},
+			accessToken: Claims{"mail": "jdoe@example.com"},
+		},
+		{
+			name:        "scope granted",
+			clientID:    "other",
+			grantScope:  []string{"openid", "groups"},
+			idToken:     Claims{"groups": []string{"staff"}},
+			accessToken: Claims{"mail": "jdoe@example.com"},
+		},
+		{
+			name:        "client listed",
+			clientID:    "app",
+			grantScope:  []string{"openid"},
+			idToken:     Claims{},
+			accessToken: Claims{"mail": "jdoe@example.com", "roles": []string{"staff"}},
+		},
+		{
+			name:        "scope granted to a client listed",
+			clientID:    "partner",
+			grantScope:  []string{"groups"},
+			idToken:     Claims{"groups": []string{"staff"}},
+			accessToken: Claims{"mail": "jdoe@example.com", "roles": []string{"staff"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idToken, accessToken := rules.Apply(traits, c.clientID, c.grantScope)
+
+			if !reflect.DeepEqual(idToken, c.idToken) {
+				t.Fatalf("ID token claims = %v, want %v", idToken, c.idToken)
+			}
+
+			if !reflect.DeepEqual(accessToken, c.accessToken) {
+				t.Fatalf("access token claims = %v, want %v", accessToken, c.accessToken)
+			}
+		})
+	}
+}
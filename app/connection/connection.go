@@ -0,0 +1,178 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Config lists the authentication connections
+	// offered in addition to the default one.
+	Config struct {
+		Connections []Connection `yaml:"connections"`
+	}
+
+	// Connection is an authentication connection. Users with emails
+	// in any of the domains are routed to it by home realm discovery.
+	Connection struct {
+		Name            string           `yaml:"name"`
+		Title           string           `yaml:"title"`
+		Type            string           `yaml:"type"`
+		Domains         []string         `yaml:"domains"`
+		IdentityManager *IdentityManager `yaml:"identity_manager"`
+		OIDC            *OIDC            `yaml:"oidc"`
+		SAML            *SAML            `yaml:"saml"`
+	}
+
+	// IdentityManager configures a connection to an identity manager.
+	// Auth is one of: none, bearer, mtls, hmac.
+	IdentityManager struct {
+		BaseURL        string `yaml:"base_url"`
+		Auth           string `yaml:"auth"`
+		BearerToken    string `yaml:"bearer_token"`
+		ClientCertFile string `yaml:"client_cert_file"`
+		ClientKeyFile  string `yaml:"client_key_file"`
+		CAFile         string `yaml:"ca_file"`
+		HMACKeyID      string `yaml:"hmac_key_id"`
+		HMACSecret     string `yaml:"hmac_secret"`
+	}
+
+	// OIDC configures a connection to an upstream OpenID Connect provider.
+	// Upstream subjects are prefixed, by default with the connection name
+	// and a colon, to keep them apart from the local ones.
+	OIDC struct {
+		Issuer        string   `yaml:"issuer"`
+		ClientID      string   `yaml:"client_id"`
+		ClientSecret  string   `yaml:"client_secret"`
+		Scopes        []string `yaml:"scopes"`
+		SubjectPrefix *string  `yaml:"subject_prefix"`
+	}
+
+	// SAML configures a connection to an upstream SAML 2.0 identity provider,
+	// described by its metadata. The service provider metadata and the ACS
+	// are served at /authentication/login/saml/<name>/metadata and /acs.
+	// The attributes override the default ones, and subjects are prefixed
+	// the same way as the ones of OIDC connections.
+	SAML struct {
+		EntityID        string          `yaml:"entity_id"`
+		ACSURL          string          `yaml:"acs_url"`
+		IdPMetadataFile string          `yaml:"idp_metadata_file"`
+		Attributes      *SAMLAttributes `yaml:"attributes"`
+		SubjectPrefix   *string         `yaml:"subject_prefix"`
+	}
+
+	// SAMLAttributes name the assertion attributes the traits are read from.
+	SAMLAttributes struct {
+		Subject    string `yaml:"subject"`
+		Email      string `yaml:"email"`
+		Name       string `yaml:"name"`
+		GivenName  string `yaml:"given_name"`
+		FamilyName string `yaml:"family_name"`
+		Groups     string `yaml:"groups"`
+	}
+)
+
+const (
+	TypeIdentityManager = "identity_manager"
+	TypeOIDC            = "oidc"
+	TypeSAML            = "saml"
+)
+
+var (
+	ErrNameMissing     = errors.New("connection name is missing")
+	ErrDuplicate       = errors.New("connection is listed more than once")
+	ErrTypeUnknown     = errors.New("connection type is unknown")
+	ErrSettingsMissing = errors.New("connection settings are missing")
+	ErrBaseURLMissing  = errors.New("identity manager base URL is missing")
+	ErrIssuerMissing   = errors.New("issuer is missing")
+	ErrClientIDMissing = errors.New("client id is missing")
+	ErrEntityIDMissing = errors.New("entity id is missing")
+	ErrACSURLMissing   = errors.New("assertion consumer service URL is missing")
+	ErrMetadataMissing = errors.New("identity provider metadata file is missing")
+)
+
+// Load reads the connections from a YAML file. JSON, being
+// a subset of YAML, is accepted as well.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connections: %w", err)
+	}
+
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode connections: %w", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *Config) Validate() error {
+	seen := make(map[string]bool, len(c.Connections))
+
+	for i := range c.Connections {
+		conn := &c.Connections[i]
+
+		switch {
+		case conn.Name == "":
+			return fmt.Errorf("connection %d: %w", i, ErrNameMissing)
+		case seen[conn.Name]:
+			return fmt.Errorf("connection %d: %s: %w", i, conn.Name, ErrDuplicate)
+		}
+
+		if err := conn.validate(); err != nil {
+			return fmt.Errorf("connection %d: %s: %w", i, conn.Name, err)
+		}
+
+		seen[conn.Name] = true
+	}
+
+	return nil
+}
+
+func (c *Connection) validate() error {
+	switch c.Type {
+	case TypeIdentityManager:
+		switch {
+		case c.IdentityManager == nil:
+			return ErrSettingsMissing
+		case c.IdentityManager.BaseURL == "":
+			return ErrBaseURLMissing
+		}
+
+		return nil
+	case TypeOIDC:
+		switch {
+		case c.OIDC == nil:
+			return ErrSettingsMissing
+		case c.OIDC.Issuer == "":
+			return ErrIssuerMissing
+		case c.OIDC.ClientID == "":
+			return ErrClientIDMissing
+		}
+
+		return nil
+	case TypeSAML:
+		switch {
+		case c.SAML == nil:
+			return ErrSettingsMissing
+		case c.SAML.EntityID == "":
+			return ErrEntityIDMissing
+		case c.SAML.ACSURL == "":
+			return ErrACSURLMissing
+		case c.SAML.IdPMetadataFile == "":
+			return ErrMetadataMissing
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrTypeUnknown, c.Type)
+	}
+}
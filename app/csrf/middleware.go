@@ -29,33 +29,60 @@ func WithToken(r *http.Request, data map[string]interface{}) map[string]interfac
 	return data
 }
 
-func protect(h httprouter.Handle) httprouter.Handle {
+// Exempt hands out a token like Protect, without checking the one sent
+// with the request. It is for the endpoints other sites post forms to,
+// which have to protect themselves in another way.
+func Exempt(h httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		w.Header().Add("Vary", "Cookie")
 
-		realToken := getTokenFromCookie(r)
+		r, _, ok := withToken(w, r)
+		if !ok {
+			DefaultErrorHandler(w, r)
+			return
+		}
 
-		if len(realToken) != tokenLength {
-			token, err := generateToken()
-			if err != nil {
-				DefaultErrorHandler(w, r)
-				return
-			}
+		h(w, r, p)
+	}
+}
 
-			setTokenCookie(w, token)
+// withToken makes the token of the request, or a new one if
+// there is none, available to the handler.
+func withToken(w http.ResponseWriter, r *http.Request) (*http.Request, []byte, bool) {
+	realToken := getTokenFromCookie(r)
 
-			r, err = setTokenContext(r, token)
-			if err != nil {
-				DefaultErrorHandler(w, r)
-				return
-			}
-		} else {
-			var err error
-			r, err = setTokenContext(r, realToken)
-			if err != nil {
-				DefaultErrorHandler(w, r)
-				return
-			}
+	if len(realToken) != tokenLength {
+		token, err := generateToken()
+		if err != nil {
+			return r, nil, false
+		}
+
+		setTokenCookie(w, token)
+
+		r, err = setTokenContext(r, token)
+		if err != nil {
+			return r, nil, false
+		}
+
+		return r, realToken, true
+	}
+
+	r, err := setTokenContext(r, realToken)
+	if err != nil {
+		return r, nil, false
+	}
+
+	return r, realToken, true
+}
+
+func protect(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Header().Add("Vary", "Cookie")
+
+		r, realToken, ok := withToken(w, r)
+		if !ok {
+			DefaultErrorHandler(w, r)
+			return
 		}
 
 		if stringInSlice(r.Method, exemptMethods) {
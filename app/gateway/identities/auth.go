@@ -0,0 +1,145 @@
+package identities
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+type (
+	authenticator interface {
+		authenticate(r *http.Request, body []byte) error
+	}
+
+	bearerToken string
+
+	hmacSigner struct {
+		keyID  string
+		secret []byte
+		now    func() time.Time
+	}
+)
+
+const (
+	// SignatureHeader carries the HMAC signature of the request, computed as
+	// HMAC-SHA256(secret, method \n escaped path and query \n timestamp \n hex(SHA256(body))).
+	SignatureHeader = "X-Signature"
+	// TimestampHeader carries the Unix time at which the request was signed.
+	TimestampHeader = "X-Signature-Timestamp"
+	// KeyIDHeader tells the identity manager which secret to verify the signature with.
+	KeyIDHeader = "X-Signature-Key-Id"
+)
+
+var (
+	ErrBearerTokenMissing = errors.New("bearer token is missing")
+	ErrHMACSecretMissing  = errors.New("hmac secret is missing")
+	ErrCAInvalid          = errors.New("no certificates found in the CA file")
+)
+
+// WithBearerToken authenticates requests with a static bearer token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) error {
+		if token == "" {
+			return ErrBearerTokenMissing
+		}
+
+		c.auth = bearerToken(token)
+
+		return nil
+	}
+}
+
+// WithClientCertificate authenticates requests with mutual TLS, using the
+// client certificate and key from the files. The CA file, if given,
+// replaces the system roots to verify the identity manager with.
+func WithClientCertificate(certFile, keyFile, caFile string) Option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		config := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return fmt.Errorf("failed to read CA file: %w", err)
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return ErrCAInvalid
+			}
+
+			config.RootCAs = pool
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+
+		c.client.Transport = transport
+
+		return nil
+	}
+}
+
+// WithHMACSigning authenticates requests by signing them with the shared secret.
+func WithHMACSigning(keyID string, secret []byte) Option {
+	return func(c *Client) error {
+		if len(secret) == 0 {
+			return ErrHMACSecretMissing
+		}
+
+		c.auth = &hmacSigner{
+			keyID:  keyID,
+			secret: secret,
+			now:    time.Now,
+		}
+
+		return nil
+	}
+}
+
+func (t bearerToken) authenticate(r *http.Request, _ []byte) error {
+	r.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+func (s *hmacSigner) authenticate(r *http.Request, body []byte) error {
+	var (
+		timestamp = strconv.FormatInt(s.now().Unix(), 10)
+		digest    = sha256.Sum256(body)
+		mac       = hmac.New(sha256.New, s.secret)
+	)
+
+	if _, err := fmt.Fprintf(mac, "%s\n%s\n%s\n%s",
+		r.Method,
+		r.URL.RequestURI(),
+		timestamp,
+		hex.EncodeToString(digest[:]),
+	); err != nil {
+		return fmt.Errorf("failed to compute signature: %w", err)
+	}
+
+	if s.keyID != "" {
+		r.Header.Set(KeyIDHeader, s.keyID)
+	}
+
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
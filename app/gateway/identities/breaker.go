@@ -0,0 +1,83 @@
+package identities
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker which opens after a number of consecutive
+// failures, failing calls fast until the cooldown passes. Then a single
+// trial call is let through, closing the breaker again if it succeeds.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if b.now().Before(b.openUntil) || b.trial {
+		return false
+	}
+
+	b.trial = true
+
+	return true
+}
+
+func (b *breaker) record(success bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// cancel gives up on the call without counting it either way,
+// letting another trial call through if it was the one.
+func (b *breaker) cancel() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
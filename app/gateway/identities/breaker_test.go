@@ -0,0 +1,98 @@
+package identities
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = time.Minute
+
+	// Each step acts on the breaker, then checks whether a call is let through.
+	steps := []struct {
+		name  string
+		act   func(b *breaker, clock *time.Time)
+		allow bool
+	}{
+		{name: "closed", act: func(*breaker, *time.Time) {}, allow: true},
+		{name: "failure below the threshold", act: fail, allow: true},
+		{name: "opened at the threshold", act: fail, allow: false},
+		{name: "open until the cooldown passes", act: wait(cooldown - time.Second), allow: false},
+		{name: "trial let through", act: wait(time.Second), allow: true},
+		{name: "only one trial let through", act: func(*breaker, *time.Time) {}, allow: false},
+		{name: "opened again by a failed trial", act: fail, allow: false},
+		{name: "trial let through again", act: wait(cooldown), allow: true},
+		{name: "cancelled trial lets another through", act: func(b *breaker, _ *time.Time) { b.cancel() }, allow: true},
+		{name: "closed by a successful trial", act: succeed, allow: true},
+		{name: "closed for everyone", act: func(*breaker, *time.Time) {}, allow: true},
+	}
+
+	var (
+		clock = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		b     = newBreaker(2, cooldown)
+	)
+
+	b.now = func() time.Time { return clock }
+
+	for _, s := range steps {
+		s.act(b, &clock)
+
+		if got := b.allow(); got != s.allow {
+			t.Fatalf("%s: call let through = %t, want %t", s.name, got, s.allow)
+		}
+	}
+}
+
+// TestClientBreaker checks that the client fails fast while the breaker
+// is open, and that giving up on a call does not count as a failure.
+func TestClientBreaker(t *testing.T) {
+	transport := &stubTransport{outcomes: []outcome{
+		{status: http.StatusInternalServerError},
+		{status: http.StatusInternalServerError},
+	}}
+
+	c, err := New("http://identities.test", WithCircuitBreaker(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.client.Transport = transport
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.Traits(ctx, "id"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled call failed with %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Traits(context.Background(), "id"); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("call %d failed with %v, want %v", i+1, err, ErrUnavailable)
+		}
+	}
+
+	if _, err := c.Traits(context.Background(), "id"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("call failed with %v while the breaker is open, want %v", err, ErrUnavailable)
+	}
+
+	if transport.requests != 2 {
+		t.Fatalf("%d requests made, want 2", transport.requests)
+	}
+}
+
+func fail(b *breaker, _ *time.Time) {
+	b.record(false)
+}
+
+func succeed(b *breaker, _ *time.Time) {
+	b.record(true)
+}
+
+func wait(d time.Duration) func(*breaker, *time.Time) {
+	return func(_ *breaker, clock *time.Time) {
+		*clock = clock.Add(d)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"path"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,16 +17,34 @@ import (
 
 type (
 	Client struct {
-		baseURL string
+		baseURL *url.URL
 		client  *http.Client
+		auth    authenticator
+		retry   *retry
+		breaker *breaker
 	}
 
+	// Identity is an authenticated identity. Traits are missing if
+	// the identity manager did not send them along, and Methods
+	// are the authentication method references of RFC 8176.
 	Identity struct {
-		ID uuid.UUID `json:"id"`
+		ID              uuid.UUID `json:"id"`
+		Traits          *Traits   `json:"traits,omitempty"`
+		Methods         []string  `json:"methods,omitempty"`
+		AuthenticatedAt time.Time `json:"authenticated_at"`
 	}
 
 	Traits struct {
-		Email string `json:"email"`
+		Email               string   `json:"email"`
+		EmailVerified       bool     `json:"email_verified"`
+		Name                string   `json:"name"`
+		GivenName           string   `json:"given_name"`
+		FamilyName          string   `json:"family_name"`
+		Picture             string   `json:"picture"`
+		Locale              string   `json:"locale"`
+		PhoneNumber         string   `json:"phone_number"`
+		PhoneNumberVerified bool     `json:"phone_number_verified"`
+		Groups              []string `json:"groups,omitempty"`
 	}
 
 	AuthenticateRequest struct {
@@ -32,15 +53,43 @@ type (
 	}
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrIdentityDisabled   = errors.New("identity is disabled")
+	ErrIdentityLocked     = errors.New("identity is locked")
+	ErrMFARequired        = errors.New("multi-factor authentication is required")
+	ErrInvalidRequest     = errors.New("request was rejected as invalid")
+	ErrIdentityNotFound   = errors.New("identity not found")
+	ErrBaseURLInvalid     = errors.New("base URL must be an absolute http or https URL")
+	ErrUnavailable        = errors.New("identity manager is unavailable")
+)
+
 const timeout = 15 * time.Second
 
-func New(baseURL string) *Client {
-	return &Client{
-		baseURL: baseURL,
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base URL: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrBaseURLInvalid
+	}
+
+	c := &Client{
+		baseURL: u,
 		client: &http.Client{
 			Timeout: timeout,
 		},
 	}
+
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
 }
 
 func (c *Client) Authenticate(ctx context.Context, email, password string) (*Identity, error) {
@@ -56,14 +105,7 @@ func (c *Client) Authenticate(ctx context.Context, email, password string) (*Ide
 		return nil, fmt.Errorf("failed to encode identity request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path.Join(c.baseURL, "/authenticate/password"), b)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create identity request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, http.MethodPost, b.Bytes(), "authenticate", "password")
 	if err != nil {
 		return nil, fmt.Errorf("failed to make identity request: %w", err)
 	}
@@ -71,11 +113,7 @@ func (c *Client) Authenticate(ctx context.Context, email, password string) (*Ide
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(
-			"request failed with status: %d %s",
-			resp.StatusCode,
-			http.StatusText(resp.StatusCode),
-		)
+		return nil, authenticationError(resp)
 	}
 
 	var identity Identity
@@ -85,3 +123,113 @@ func (c *Client) Authenticate(ctx context.Context, email, password string) (*Ide
 
 	return &identity, nil
 }
+
+func (c *Client) Traits(ctx context.Context, id string) (*Traits, error) {
+	resp, err := c.do(ctx, http.MethodGet, nil, "identities", id, "traits")
+	if err != nil {
+		return nil, fmt.Errorf("failed to make traits request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrIdentityNotFound
+	default:
+		return nil, statusError(resp)
+	}
+
+	var traits Traits
+	if err := json.NewDecoder(resp.Body).Decode(&traits); err != nil {
+		return nil, fmt.Errorf("failed to decode traits response: %w", err)
+	}
+
+	return &traits, nil
+}
+
+// do makes the request, retrying it and tripping the circuit
+// breaker as configured. Failures of the identity manager
+// itself are reported as ErrUnavailable.
+func (c *Client) do(ctx context.Context, method string, body []byte, segments ...string) (*http.Response, error) {
+	if !c.breaker.allow() {
+		return nil, ErrUnavailable
+	}
+
+	resp, err := c.retry.do(ctx, method, func() (*http.Response, error) {
+		return c.attempt(ctx, method, body, segments...)
+	})
+
+	// The caller giving up says nothing about the identity manager.
+	if ctx.Err() != nil {
+		c.breaker.cancel()
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		c.breaker.record(false)
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	c.breaker.record(resp.StatusCode < http.StatusInternalServerError)
+
+	return resp, nil
+}
+
+func (c *Client) attempt(ctx context.Context, method string, body []byte, segments ...string) (*http.Response, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(segments...), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.auth != nil {
+		if err := c.auth.authenticate(req, body); err != nil {
+			return nil, fmt.Errorf("failed to authenticate request: %w", err)
+		}
+	}
+
+	return c.client.Do(req)
+}
+
+// endpoint resolves the path segments against the base URL,
+// keeping its scheme, host and any path prefix intact.
+func (c *Client) endpoint(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.Join(segments, "/")
+	u.RawPath = strings.TrimSuffix(c.baseURL.EscapedPath(), "/") + "/" + strings.Join(escaped, "/")
+
+	return u.String()
+}
+
+func statusError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: status %d %s", ErrUnavailable, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	return fmt.Errorf(
+		"request failed with status: %d %s",
+		resp.StatusCode,
+		http.StatusText(resp.StatusCode),
+	)
+}
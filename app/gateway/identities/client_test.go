@@ -0,0 +1,337 @@
+package identities_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/gateway/identities"
+)
+
+func TestEndpoint(t *testing.T) {
+	cases := []struct {
+		name     string
+		basePath string
+		id       string
+		want     string
+	}{
+		{name: "no prefix", id: "id", want: "/identities/id/traits"},
+		{name: "root", basePath: "/", id: "id", want: "/identities/id/traits"},
+		{name: "prefix", basePath: "/identity", id: "id", want: "/identity/identities/id/traits"},
+		{name: "prefix with slash", basePath: "/identity/", id: "id", want: "/identity/identities/id/traits"},
+		{name: "escaped prefix", basePath: "/identity%20manager", id: "id", want: "/identity%20manager/identities/id/traits"},
+		{name: "escaped id", basePath: "/identity", id: "a/b?c", want: "/identity/identities/a%2Fb%3Fc/traits"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requestURI string
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestURI = r.RequestURI
+				_, _ = io.WriteString(w, `{"email":"jdoe@example.com"}`)
+			}))
+			t.Cleanup(server.Close)
+
+			client, err := identities.New(server.URL + c.basePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.Traits(context.Background(), c.id); err != nil {
+				t.Fatal(err)
+			}
+
+			if requestURI != c.want {
+				t.Fatalf("requested %s, want %s", requestURI, c.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name    string
+		baseURL string
+		opts    []identities.Option
+		err     error
+	}{
+		{name: "valid", baseURL: "https://identities.test/api"},
+		{name: "relative", baseURL: "/api", err: identities.ErrBaseURLInvalid},
+		{name: "no scheme", baseURL: "identities.test", err: identities.ErrBaseURLInvalid},
+		{name: "other scheme", baseURL: "ftp://identities.test", err: identities.ErrBaseURLInvalid},
+		{
+			name:    "bearer token missing",
+			baseURL: "https://identities.test",
+			opts:    []identities.Option{identities.WithBearerToken("")},
+			err:     identities.ErrBearerTokenMissing,
+		},
+		{
+			name:    "hmac secret missing",
+			baseURL: "https://identities.test",
+			opts:    []identities.Option{identities.WithHMACSigning("key", nil)},
+			err:     identities.ErrHMACSecretMissing,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := identities.New(c.baseURL, c.opts...)
+
+			if !errors.Is(err, c.err) || (c.err == nil && err != nil) {
+				t.Fatalf("creating the client failed with %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestRequestAuthentication(t *testing.T) {
+	secret := []byte("hmac-secret")
+
+	cases := []struct {
+		name   string
+		opts   []identities.Option
+		verify func(r *http.Request, body []byte) error
+	}{
+		{
+			name: "bearer token",
+			opts: []identities.Option{identities.WithBearerToken("token")},
+			verify: func(r *http.Request, _ []byte) error {
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					return fmt.Errorf("authorization = %q", got)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "hmac signature",
+			opts: []identities.Option{identities.WithHMACSigning("key-1", secret)},
+			verify: func(r *http.Request, body []byte) error {
+				if got := r.Header.Get(identities.KeyIDHeader); got != "key-1" {
+					return fmt.Errorf("key id = %q", got)
+				}
+
+				return verifySignature(r, body, secret)
+			},
+		},
+		{
+			name: "hmac signature without key id",
+			opts: []identities.Option{identities.WithHMACSigning("", secret)},
+			verify: func(r *http.Request, body []byte) error {
+				if _, ok := r.Header[identities.KeyIDHeader]; ok {
+					return errors.New("key id sent")
+				}
+
+				return verifySignature(r, body, secret)
+			},
+		},
+		{
+			name: "none",
+			verify: func(r *http.Request, _ []byte) error {
+				for _, h := range []string{"Authorization", identities.SignatureHeader} {
+					if r.Header.Get(h) != "" {
+						return fmt.Errorf("%s sent", h)
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				if err := c.verify(r, body); err != nil {
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+
+				_, _ = io.WriteString(w, `{"id":"6f1c2a1e-94c6-4d5e-9c52-2f0a5b7f3f10"}`)
+			}))
+			t.Cleanup(server.Close)
+
+			client, err := identities.New(server.URL+"/api", c.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.Authenticate(context.Background(), "jdoe@example.com", "secret"); err != nil {
+				t.Fatalf("request refused: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		clientCert = newCertificate(t, "identity-provider")
+		pool       = x509.NewCertPool()
+	)
+
+	pool.AddCert(clientCert.Leaf)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"email":%q}`, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	// The handshakes refused on purpose are not worth logging.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	var (
+		certFile = writePEM(t, dir, "client.crt", "CERTIFICATE", clientCert.Certificate[0])
+		keyFile  = writePEM(t, dir, "client.key", "PRIVATE KEY", marshalKey(t, clientCert.PrivateKey))
+		caFile   = writePEM(t, dir, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	)
+
+	cases := []struct {
+		name     string
+		opts     []identities.Option
+		newErr   error
+		accepted bool
+	}{
+		{
+			name:     "presented",
+			opts:     []identities.Option{identities.WithClientCertificate(certFile, keyFile, caFile)},
+			accepted: true,
+		},
+		{
+			name: "server not trusted",
+			opts: []identities.Option{identities.WithClientCertificate(certFile, keyFile, "")},
+		},
+		{
+			name:   "CA file without certificates",
+			opts:   []identities.Option{identities.WithClientCertificate(certFile, keyFile, keyFile)},
+			newErr: identities.ErrCAInvalid,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := identities.New(server.URL, c.opts...)
+			if !errors.Is(err, c.newErr) || (c.newErr == nil && err != nil) {
+				t.Fatalf("creating the client failed with %v, want %v", err, c.newErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			traits, err := client.Traits(context.Background(), "id")
+
+			switch {
+			case c.accepted && err != nil:
+				t.Fatalf("request refused: %v", err)
+			case c.accepted && traits.Email != "identity-provider":
+				t.Fatalf("server saw %q", traits.Email)
+			case !c.accepted && err == nil:
+				t.Fatal("request accepted")
+			}
+		})
+	}
+}
+
+// verifySignature checks the signature as the identity manager does.
+func verifySignature(r *http.Request, body, secret []byte) error {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.RequestURI, r.Header.Get(identities.TimestampHeader), hex.EncodeToString(digest[:]))
+
+	got, err := base64.StdEncoding.DecodeString(r.Header.Get(identities.SignatureHeader))
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature is invalid")
+	}
+
+	if !strings.HasPrefix(r.RequestURI, "/api/") {
+		return fmt.Errorf("signed %s", r.RequestURI)
+	}
+
+	return nil
+}
+
+func newCertificate(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func marshalKey(t *testing.T, key interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
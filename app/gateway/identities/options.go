@@ -0,0 +1,50 @@
+package identities
+
+import (
+	"errors"
+	"time"
+)
+
+// Option configures the client.
+type Option func(*Client) error
+
+var (
+	ErrRetryInvalid   = errors.New("retry needs at least one attempt and a valid backoff")
+	ErrBreakerInvalid = errors.New("circuit breaker needs a positive threshold and cooldown")
+)
+
+// WithTimeout limits how long a single attempt of a request may take.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.client.Timeout = timeout
+		return nil
+	}
+}
+
+// WithRetry makes up to the given number of attempts for each request,
+// backing off exponentially from base up to max in between.
+func WithRetry(attempts int, base, max time.Duration) Option {
+	return func(c *Client) error {
+		if attempts < 1 || base <= 0 || max < base {
+			return ErrRetryInvalid
+		}
+
+		c.retry = &retry{attempts: attempts, base: base, max: max}
+
+		return nil
+	}
+}
+
+// WithCircuitBreaker fails requests fast for the cooldown
+// after the threshold of consecutive failures is reached.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) error {
+		if threshold < 1 || cooldown <= 0 {
+			return ErrBreakerInvalid
+		}
+
+		c.breaker = newBreaker(threshold, cooldown)
+
+		return nil
+	}
+}
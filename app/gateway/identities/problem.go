@@ -0,0 +1,92 @@
+package identities
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Problem is an error response of the identity manager,
+// as described by RFC 7807.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Problem types the identity manager reports authentication failures with.
+// The type may also be a URI ending with one of them.
+const (
+	ProblemInvalidCredentials = "invalid_credentials"
+	ProblemIdentityLocked     = "identity_locked"
+	ProblemIdentityDisabled   = "identity_disabled"
+	ProblemMFARequired        = "mfa_required"
+	ProblemValidation         = "validation_failed"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	maxProblemSize     = 64 << 10
+)
+
+var problemErrors = map[string]error{
+	ProblemInvalidCredentials: ErrInvalidCredentials,
+	ProblemIdentityLocked:     ErrIdentityLocked,
+	ProblemIdentityDisabled:   ErrIdentityDisabled,
+	ProblemMFARequired:        ErrMFARequired,
+	ProblemValidation:         ErrInvalidRequest,
+}
+
+// readProblem decodes the problem body of the response, if it has one.
+func readProblem(resp *http.Response) (*Problem, bool) {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != problemContentType {
+		return nil, false
+	}
+
+	var p Problem
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxProblemSize)).Decode(&p); err != nil {
+		return nil, false
+	}
+
+	return &p, true
+}
+
+// err returns the error the problem type stands for,
+// or nil if it is not a known one.
+func (p *Problem) err() error {
+	t := p.Type
+	if i := strings.LastIndexAny(t, "/#"); i >= 0 {
+		t = t[i+1:]
+	}
+
+	return problemErrors[t]
+}
+
+// authenticationError tells why the identity manager refused
+// to authenticate, preferring the problem type over the status.
+func authenticationError(resp *http.Response) error {
+	if resp.StatusCode < http.StatusInternalServerError {
+		if p, ok := readProblem(resp); ok {
+			if err := p.err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrInvalidRequest
+	case http.StatusUnauthorized, http.StatusNotFound:
+		return ErrInvalidCredentials
+	case http.StatusForbidden:
+		return ErrIdentityDisabled
+	case http.StatusLocked:
+		return ErrIdentityLocked
+	default:
+		return statusError(resp)
+	}
+}
@@ -0,0 +1,120 @@
+package identities
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticationError(t *testing.T) {
+	cases := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		err         error
+	}{
+		{
+			name:        "problem type",
+			status:      http.StatusUnauthorized,
+			contentType: problemContentType,
+			body:        `{"type":"mfa_required","status":401}`,
+			err:         ErrMFARequired,
+		},
+		{
+			name:        "problem type URI",
+			status:      http.StatusForbidden,
+			contentType: problemContentType,
+			body:        `{"type":"https://identities.test/problems/identity_locked"}`,
+			err:         ErrIdentityLocked,
+		},
+		{
+			name:        "problem type URI fragment",
+			status:      http.StatusForbidden,
+			contentType: problemContentType + "; charset=utf-8",
+			body:        `{"type":"https://identities.test/problems#identity_disabled"}`,
+			err:         ErrIdentityDisabled,
+		},
+		{
+			name:        "validation problem",
+			status:      http.StatusUnprocessableEntity,
+			contentType: problemContentType,
+			body:        `{"type":"validation_failed","detail":"email is invalid"}`,
+			err:         ErrInvalidRequest,
+		},
+		{
+			name:        "problem type preferred over the status",
+			status:      http.StatusForbidden,
+			contentType: problemContentType,
+			body:        `{"type":"invalid_credentials"}`,
+			err:         ErrInvalidCredentials,
+		},
+		{
+			name:        "unknown problem type",
+			status:      http.StatusForbidden,
+			contentType: problemContentType,
+			body:        `{"type":"about:blank"}`,
+			err:         ErrIdentityDisabled,
+		},
+		{
+			name:        "malformed problem",
+			status:      http.StatusUnauthorized,
+			contentType: problemContentType,
+			body:        `{"type":`,
+			err:         ErrInvalidCredentials,
+		},
+		{
+			name:        "problem of another content type",
+			status:      http.StatusLocked,
+			contentType: "application/json",
+			body:        `{"type":"mfa_required"}`,
+			err:         ErrIdentityLocked,
+		},
+		{
+			name:   "bad request",
+			status: http.StatusBadRequest,
+			err:    ErrInvalidRequest,
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			err:    ErrInvalidCredentials,
+		},
+		{
+			name:        "server error",
+			status:      http.StatusServiceUnavailable,
+			contentType: problemContentType,
+			body:        `{"type":"invalid_credentials"}`,
+			err:         ErrUnavailable,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: c.status,
+				Header:     http.Header{"Content-Type": {c.contentType}},
+				Body:       io.NopCloser(strings.NewReader(c.body)),
+			}
+
+			if err := authenticationError(resp); !errors.Is(err, c.err) {
+				t.Fatalf("error = %v, want %v", err, c.err)
+			}
+		})
+	}
+
+	t.Run("unexpected status", func(t *testing.T) {
+		err := authenticationError(&http.Response{
+			StatusCode: http.StatusTeapot,
+			Body:       http.NoBody,
+		})
+
+		for _, known := range []error{ErrInvalidCredentials, ErrIdentityDisabled, ErrInvalidRequest, ErrUnavailable} {
+			if errors.Is(err, known) {
+				t.Fatalf("error = %v, want none of the known ones", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package identities
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// retry makes up to the configured number of attempts, backing off
+// exponentially with full jitter in between, as long as the deadline of
+// the caller allows for it. Idempotent requests are retried on transport
+// failures and server errors, while the others only if they could not
+// be sent at all, as they may have taken effect otherwise.
+type retry struct {
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+func (r *retry) do(ctx context.Context, method string, attempt func() (*http.Response, error)) (*http.Response, error) {
+	attempts := 1
+	if r != nil && r.attempts > 1 {
+		attempts = r.attempts
+	}
+
+	for i := 1; ; i++ {
+		resp, err := attempt()
+
+		if i == attempts || ctx.Err() != nil || !retryable(method, resp, err) {
+			return resp, err
+		}
+
+		wait := r.backoff(i)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (r *retry) backoff(attempt int) time.Duration {
+	d := r.base << (attempt - 1)
+	if d <= 0 || d > r.max {
+		d = r.max
+	}
+
+	//nolint:gosec // jitter does not need a cryptographically secure source
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func retryable(method string, resp *http.Response, err error) bool {
+	idempotent := method == http.MethodGet || method == http.MethodHead
+
+	if err != nil {
+		var opErr *net.OpError
+		return idempotent || (errors.As(err, &opErr) && opErr.Op == "dial")
+	}
+
+	if !idempotent {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,186 @@
+package identities
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubTransport answers each request with the next of its outcomes:
+// an error, or else a response of the status.
+type stubTransport struct {
+	mu       sync.Mutex
+	outcomes []outcome
+	requests int
+}
+
+type outcome struct {
+	status int
+	err    error
+}
+
+var (
+	errDial = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	errRead = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+)
+
+func (s *stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := outcome{status: http.StatusOK}
+	if s.requests < len(s.outcomes) {
+		o = s.outcomes[s.requests]
+	}
+
+	s.requests++
+
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	return &http.Response{
+		StatusCode: o.status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    r,
+	}, nil
+}
+
+func TestRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		outcomes []outcome
+		requests int
+		status   int
+	}{
+		{
+			name:     "read retried",
+			method:   http.MethodGet,
+			outcomes: []outcome{{status: http.StatusServiceUnavailable}, {status: http.StatusOK}},
+			requests: 2,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "read retried after transport failure",
+			method:   http.MethodGet,
+			outcomes: []outcome{{err: errRead}, {status: http.StatusOK}},
+			requests: 2,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "read given up on",
+			method:   http.MethodGet,
+			outcomes: []outcome{{status: http.StatusBadGateway}, {status: http.StatusGatewayTimeout}, {status: http.StatusBadGateway}},
+			requests: 3,
+			status:   http.StatusBadGateway,
+		},
+		{
+			name:     "read not retried on internal server error",
+			method:   http.MethodGet,
+			outcomes: []outcome{{status: http.StatusInternalServerError}},
+			requests: 1,
+			status:   http.StatusInternalServerError,
+		},
+		{
+			name:     "read not retried on client error",
+			method:   http.MethodGet,
+			outcomes: []outcome{{status: http.StatusNotFound}},
+			requests: 1,
+			status:   http.StatusNotFound,
+		},
+		{
+			name:     "write not retried on server error",
+			method:   http.MethodPost,
+			outcomes: []outcome{{status: http.StatusServiceUnavailable}},
+			requests: 1,
+			status:   http.StatusServiceUnavailable,
+		},
+		{
+			name:     "write not retried once sent",
+			method:   http.MethodPost,
+			outcomes: []outcome{{err: errRead}},
+			requests: 1,
+		},
+		{
+			name:     "write retried if never sent",
+			method:   http.MethodPost,
+			outcomes: []outcome{{err: errDial}, {err: errDial}, {status: http.StatusOK}},
+			requests: 3,
+			status:   http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				transport = &stubTransport{outcomes: c.outcomes}
+				client    = &http.Client{Transport: transport}
+				r         = &retry{attempts: 3, base: time.Millisecond, max: 2 * time.Millisecond}
+			)
+
+			resp, err := r.do(context.Background(), c.method, func() (*http.Response, error) {
+				return client.Do(newRequest(t, c.method))
+			})
+
+			switch {
+			case c.status == 0 && err == nil:
+				t.Fatalf("request succeeded with %d", resp.StatusCode)
+			case c.status != 0 && err != nil:
+				t.Fatalf("request failed: %v", err)
+			case c.status != 0 && resp.StatusCode != c.status:
+				t.Fatalf("status = %d, want %d", resp.StatusCode, c.status)
+			}
+
+			if transport.requests != c.requests {
+				t.Fatalf("%d requests made, want %d", transport.requests, c.requests)
+			}
+		})
+	}
+}
+
+// TestRetryDeadline checks that no retry is made if the caller
+// would give up before the backoff is over.
+func TestRetryDeadline(t *testing.T) {
+	var (
+		transport = &stubTransport{outcomes: []outcome{{status: http.StatusServiceUnavailable}}}
+		client    = &http.Client{Transport: transport}
+		r         = &retry{attempts: 3, base: time.Hour, max: time.Hour}
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := r.do(ctx, http.MethodGet, func() (*http.Response, error) {
+		return client.Do(newRequest(t, http.MethodGet))
+	})
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("request ended with %v, %v", resp, err)
+	}
+
+	if transport.requests != 1 {
+		t.Fatalf("%d requests made, want 1", transport.requests)
+	}
+}
+
+func newRequest(t *testing.T, method string) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(method, "http://identities.test/authenticate/password", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
@@ -0,0 +1,258 @@
+// Package identitiestest provides a fake identity manager,
+// served over HTTP, for use in integration tests.
+package identitiestest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mpraski/identity-provider/app/gateway/identities"
+)
+
+type (
+	// Server is a fake identity manager holding a fixed set of users.
+	Server struct {
+		*httptest.Server
+
+		mu       sync.Mutex
+		users    map[string]*User
+		faults   []*Fault
+		requests []Request
+	}
+
+	User struct {
+		ID          uuid.UUID
+		Email       string
+		Password    string
+		Disabled    bool
+		Locked      bool
+		MFARequired bool
+		// Methods are reported as used to authenticate, if set.
+		Methods []string
+		Traits  identities.Traits
+	}
+
+	// Fault makes the server misbehave for the matching requests.
+	Fault struct {
+		// Path limits the fault to requests to it. Empty matches all.
+		Path string
+		// Latency delays the response.
+		Latency time.Duration
+		// Status, if set, is returned instead of the regular response.
+		Status int
+		// Malformed makes the server respond with invalid JSON.
+		Malformed bool
+		// Times limits how many requests are affected. Zero means all.
+		Times int
+	}
+
+	// Request records a request received by the server.
+	Request struct {
+		Method string
+		Path   string
+		Header http.Header
+		Body   []byte
+	}
+)
+
+const (
+	authenticatePath = "/authenticate/password"
+	identitiesPath   = "/identities/"
+	traitsSuffix     = "/traits"
+)
+
+func NewServer(users ...User) *Server {
+	s := &Server{users: make(map[string]*User)}
+
+	for i := range users {
+		s.AddUser(users[i])
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// AddUser adds the user, generating its ID if missing.
+func (s *Server) AddUser(u User) uuid.UUID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+
+	if u.Traits.Email == "" {
+		u.Traits.Email = u.Email
+	}
+
+	s.users[strings.ToLower(u.Email)] = &u
+
+	return u.ID
+}
+
+// Inject makes the server misbehave until the fault is used up or cleared.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &f)
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+
+	return requests
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	f := s.record(r, body)
+
+	if f != nil {
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if f.Status != 0 {
+			http.Error(w, http.StatusText(f.Status), f.Status)
+			return
+		}
+
+		if f.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"id": `)
+
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == authenticatePath:
+		s.authenticate(w, r)
+	case r.Method == http.MethodGet &&
+		strings.HasPrefix(r.URL.Path, identitiesPath) &&
+		strings.HasSuffix(r.URL.Path, traitsSuffix):
+		s.traits(w, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, identitiesPath), traitsSuffix))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) {
+	var req identities.AuthenticateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.users[strings.ToLower(req.Email)]
+	s.mu.Unlock()
+
+	switch {
+	case req.Email == "" || req.Password == "":
+		writeProblem(w, http.StatusUnprocessableEntity, identities.ProblemValidation)
+	case !ok || u.Password != req.Password:
+		writeProblem(w, http.StatusUnauthorized, identities.ProblemInvalidCredentials)
+	case u.Disabled:
+		writeProblem(w, http.StatusForbidden, identities.ProblemIdentityDisabled)
+	case u.Locked:
+		writeProblem(w, http.StatusForbidden, identities.ProblemIdentityLocked)
+	case u.MFARequired:
+		writeProblem(w, http.StatusUnauthorized, identities.ProblemMFARequired)
+	default:
+		writeJSON(w, &identities.Identity{
+			ID:              u.ID,
+			Traits:          &u.Traits,
+			Methods:         u.Methods,
+			AuthenticatedAt: time.Now().UTC(),
+		})
+	}
+}
+
+func (s *Server) traits(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ID.String() == id {
+			writeJSON(w, &u.Traits)
+			return
+		}
+	}
+
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// record saves the request and returns the fault to apply to it, if any.
+func (s *Server) record(r *http.Request, body []byte) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+
+	for i, f := range s.faults {
+		if f.Path != "" && f.Path != r.URL.Path {
+			continue
+		}
+
+		if f.Times > 0 {
+			f.Times--
+
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+
+		return f
+	}
+
+	return nil
+}
+
+func writeProblem(w http.ResponseWriter, status int, problem string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(&identities.Problem{
+		Type:   problem,
+		Title:  http.StatusText(status),
+		Status: status,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
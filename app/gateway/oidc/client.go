@@ -0,0 +1,275 @@
+// Package oidc is a client of an upstream OpenID Connect identity
+// provider, using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	Client struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		scopes       []string
+		client       *http.Client
+		now          func() time.Time
+
+		mu        sync.Mutex
+		discovery *Discovery
+		keys      *keySet
+	}
+
+	// Discovery is the provider metadata, as described
+	// by OpenID Connect Discovery 1.0.
+	Discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	// Token is the response of the token endpoint.
+	Token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		IDToken     string `json:"id_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	// tokenError is the error response of the token endpoint.
+	tokenError struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+var (
+	ErrIssuerInvalid    = errors.New("issuer must be an absolute http or https URL")
+	ErrClientIDMissing  = errors.New("client id is missing")
+	ErrDiscoveryInvalid = errors.New("provider metadata is invalid")
+	ErrUnavailable      = errors.New("identity provider is unavailable")
+	ErrGrantInvalid     = errors.New("authorization code was rejected")
+	ErrIDTokenMissing   = errors.New("token response has no id token")
+)
+
+const (
+	timeout       = 15 * time.Second
+	discoveryPath = "/.well-known/openid-configuration"
+	maxBodySize   = 1 << 20
+)
+
+// DefaultScopes are requested unless configured otherwise.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// New creates a client of the issuer. A client secret is optional,
+// as PKCE protects the code exchange of public clients as well.
+func New(issuer, clientID, clientSecret string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issuer: %w", err)
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrIssuerInvalid
+	}
+
+	if clientID == "" {
+		return nil, ErrClientIDMissing
+	}
+
+	c := &Client{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       DefaultScopes,
+		client:       &http.Client{Timeout: timeout},
+		now:          time.Now,
+	}
+
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// AuthCodeURL returns where to send the user to authenticate. The state
+// and nonce bind the response to this request, while the code challenge
+// is derived from the verifier, to be presented again on exchange.
+func (c *Client) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, verifier string) (string, error) {
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDiscoveryInvalid, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", c.clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(c.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange trades the authorization code for tokens.
+func (c *Client) Exchange(ctx context.Context, code, redirectURI, verifier string) (*Token, error) {
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	if c.clientSecret == "" {
+		form.Set("client_id", c.clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if c.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: token status %d", ErrUnavailable, resp.StatusCode)
+	default:
+		var e tokenError
+		_ = json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&e)
+
+		return nil, fmt.Errorf("%w: %s %s", ErrGrantInvalid, e.Error, e.ErrorDescription)
+	}
+
+	var t Token
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&t); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	if t.IDToken == "" {
+		return nil, ErrIDTokenMissing
+	}
+
+	return &t, nil
+}
+
+// Discover fetches the provider metadata once, checking
+// that it is actually about the configured issuer.
+func (c *Client) Discover(ctx context.Context) (*Discovery, error) {
+	c.mu.Lock()
+	d := c.discovery
+	c.mu.Unlock()
+
+	if d != nil {
+		return d, nil
+	}
+
+	var fetched Discovery
+	if err := c.get(ctx, c.issuer+discoveryPath, &fetched); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+
+	switch {
+	case strings.TrimSuffix(fetched.Issuer, "/") != c.issuer:
+		return nil, fmt.Errorf("%w: issuer %q does not match", ErrDiscoveryInvalid, fetched.Issuer)
+	case fetched.AuthorizationEndpoint == "", fetched.TokenEndpoint == "", fetched.JWKSURI == "":
+		return nil, fmt.Errorf("%w: endpoints are missing", ErrDiscoveryInvalid)
+	}
+
+	c.mu.Lock()
+	c.discovery = &fetched
+	c.mu.Unlock()
+
+	return &fetched, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode)
+	default:
+		return fmt.Errorf("request failed with status: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// RandomString returns a random URL-safe string, suitable
+// for a state, a nonce or a PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE code challenge from the verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+	"github.com/mpraski/identity-provider/app/gateway/oidctest"
+)
+
+const redirectURI = "https://idp.test/authentication/login/callback"
+
+// noRedirects lets the test look at where the issuer sends the user.
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestClient(t *testing.T) {
+	cases := []struct {
+		name   string
+		secret string
+		tamper oidctest.Tamper
+		// nonce and verifier replace the ones the handoff was made with.
+		nonce    string
+		verifier string
+		err      error
+	}{
+		{name: "confidential client", secret: "client-secret"},
+		{name: "public client"},
+		{name: "signature tampered", tamper: oidctest.Tamper{Signature: true}, err: oidc.ErrSignatureInvalid},
+		{name: "nonce tampered", tamper: oidctest.Tamper{Nonce: true}, err: oidc.ErrNonceMismatch},
+		{name: "audience tampered", tamper: oidctest.Tamper{Audience: true}, err: oidc.ErrAudienceMismatch},
+		{name: "issuer tampered", tamper: oidctest.Tamper{Issuer: true}, err: oidc.ErrIssuerMismatch},
+		{name: "token expired", tamper: oidctest.Tamper{Expired: true}, err: oidc.ErrTokenExpired},
+		{name: "nonce of another login", nonce: "another-nonce", err: oidc.ErrNonceMismatch},
+		{name: "verifier of another login", verifier: "another-verifier", err: oidc.ErrGrantInvalid},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			issuer := oidctest.NewIssuer("client", c.secret)
+			t.Cleanup(issuer.Close)
+
+			issuer.SetClaims(map[string]interface{}{"sub": "jdoe", "email": "jdoe@example.com"})
+			issuer.SetTamper(c.tamper)
+
+			client, err := oidc.New(issuer.URL, "client", c.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+
+			code := authorize(t, client, "state", "nonce", "verifier")
+
+			verifier := "verifier"
+			if c.verifier != "" {
+				verifier = c.verifier
+			}
+
+			token, err := client.Exchange(ctx, code, redirectURI, verifier)
+			if err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("exchange failed with %v, want %v", err, c.err)
+				}
+
+				return
+			}
+
+			nonce := "nonce"
+			if c.nonce != "" {
+				nonce = c.nonce
+			}
+
+			idToken, err := client.Verify(ctx, token.IDToken, nonce)
+
+			switch {
+			case c.err != nil && !errors.Is(err, c.err):
+				t.Fatalf("verification failed with %v, want %v", err, c.err)
+			case c.err == nil && err != nil:
+				t.Fatalf("verification failed: %v", err)
+			case c.err == nil && (idToken.Subject != "jdoe" || idToken.Claims["email"] != "jdoe@example.com"):
+				t.Fatalf("ID token = %+v", idToken)
+			}
+		})
+	}
+}
+
+// authorize sends the user to the issuer and returns the code it
+// redirects back with, checking that the state is handed back.
+func authorize(t *testing.T, client *oidc.Client, state, nonce, verifier string) string {
+	t.Helper()
+
+	u, err := client.AuthCodeURL(context.Background(), redirectURI, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := noRedirects.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := callback.Query()
+	if q.Get("state") != state || q.Get("code") == "" {
+		t.Fatalf("redirected back to %s", callback)
+	}
+
+	return q.Get("code")
+}
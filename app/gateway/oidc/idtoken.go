@@ -0,0 +1,226 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type (
+	// IDToken holds the verified claims of an ID token. Claims
+	// has all of them, including the ones without a field.
+	IDToken struct {
+		Issuer          string   `json:"iss"`
+		Subject         string   `json:"sub"`
+		Audience        audience `json:"aud"`
+		AuthorizedParty string   `json:"azp,omitempty"`
+		Expiry          int64    `json:"exp"`
+		IssuedAt        int64    `json:"iat"`
+		AuthTime        int64    `json:"auth_time,omitempty"`
+		Nonce           string   `json:"nonce,omitempty"`
+		ACR             string   `json:"acr,omitempty"`
+		AMR             []string `json:"amr,omitempty"`
+
+		Claims map[string]interface{} `json:"-"`
+	}
+
+	header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid,omitempty"`
+	}
+
+	// audience is either a single string or an array of them.
+	audience []string
+)
+
+var (
+	ErrTokenMalformed       = errors.New("token is malformed")
+	ErrAlgorithmUnsupported = errors.New("token signing algorithm is not supported")
+	ErrSignatureInvalid     = errors.New("token signature is invalid")
+	ErrIssuerMismatch       = errors.New("token issuer does not match")
+	ErrAudienceMismatch     = errors.New("token audience does not match")
+	ErrTokenExpired         = errors.New("token has expired")
+	ErrTokenNotYetValid     = errors.New("token was issued in the future")
+	ErrNonceMismatch        = errors.New("token nonce does not match")
+	ErrSubjectMissing       = errors.New("token subject is missing")
+)
+
+// leeway tolerates clock skew between the provider and this service.
+const leeway = time.Minute
+
+// Verify checks the signature of the ID token against the keys of the
+// provider and validates its claims, including the expected nonce.
+func (c *Client) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	hash, ok := hashes[h.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrAlgorithmUnsupported, h.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	key, err := c.key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(h.Algorithm, hash, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var t IDToken
+	if err := decodeSegment(parts[1], &t); err != nil {
+		return nil, err
+	}
+
+	if err := decodeSegment(parts[1], &t.Claims); err != nil {
+		return nil, err
+	}
+
+	if err := c.validate(&t, nonce); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (c *Client) validate(t *IDToken, nonce string) error {
+	now := c.now()
+
+	switch {
+	case strings.TrimSuffix(t.Issuer, "/") != c.issuer:
+		return fmt.Errorf("%w: %q", ErrIssuerMismatch, t.Issuer)
+	case !t.Audience.contains(c.clientID):
+		return ErrAudienceMismatch
+	case len(t.Audience) > 1 && t.AuthorizedParty != c.clientID:
+		return ErrAudienceMismatch
+	case now.After(time.Unix(t.Expiry, 0).Add(leeway)):
+		return ErrTokenExpired
+	case time.Unix(t.IssuedAt, 0).After(now.Add(leeway)):
+		return ErrTokenNotYetValid
+	case nonce == "" || t.Nonce != nonce:
+		return ErrNonceMismatch
+	case t.Subject == "":
+		return ErrSubjectMissing
+	}
+
+	return nil
+}
+
+var hashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) error {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(k, hash, digest, signature, nil)
+		default:
+			return ErrSignatureInvalid
+		}
+
+		if err != nil {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return ErrSignatureInvalid
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	default:
+		return ErrSignatureInvalid
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	}
+
+	return nil
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+
+	*a = many
+
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
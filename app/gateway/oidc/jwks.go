@@ -0,0 +1,216 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+type (
+	// JWK is a public key, as described by RFC 7517.
+	JWK struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid,omitempty"`
+		Use       string `json:"use,omitempty"`
+		Algorithm string `json:"alg,omitempty"`
+		// RSA keys
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// Elliptic curve keys
+		Curve string `json:"crv,omitempty"`
+		X     string `json:"x,omitempty"`
+		Y     string `json:"y,omitempty"`
+	}
+
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	keySet struct {
+		keys    map[string]crypto.PublicKey
+		fetched time.Time
+	}
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	ErrKeyInvalid  = errors.New("signing key is invalid")
+)
+
+// keyRefreshInterval limits how often the keys are fetched again
+// when a token is signed with an unknown key, such as after rotation.
+const keyRefreshInterval = time.Minute
+
+// key returns the public key of the given id, fetching
+// the key set again if the key is not known yet.
+func (c *Client) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+
+	if keys != nil {
+		if k, ok := keys.lookup(kid); ok {
+			return k, nil
+		}
+
+		if c.now().Sub(keys.fetched) < keyRefreshInterval {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+		}
+	}
+
+	keys, err := c.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	if k, ok := keys.lookup(kid); ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+}
+
+func (c *Client) fetchKeys(ctx context.Context) (*keySet, error) {
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks JWKS
+	if err := c.get(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+
+	keys := &keySet{
+		keys:    make(map[string]crypto.PublicKey, len(jwks.Keys)),
+		fetched: c.now(),
+	}
+
+	for i := range jwks.Keys {
+		if jwks.Keys[i].Use != "" && jwks.Keys[i].Use != "sig" {
+			continue
+		}
+
+		k, err := jwks.Keys[i].PublicKey()
+		if err != nil {
+			// Keys of unsupported types are of no use, but neither do they
+			// prevent the rest of them from being used.
+			continue
+		}
+
+		keys.keys[jwks.Keys[i].KeyID] = k
+	}
+
+	return keys, nil
+}
+
+// lookup returns the key of the given id. Tokens without
+// a key id may only be verified if there is a single key.
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+
+	k, ok := s.keys[kid]
+
+	return k, ok
+}
+
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, ErrKeyInvalid
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", ErrKeyInvalid, k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrKeyInvalid
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("%w: type %q", ErrKeyInvalid, k.KeyType)
+	}
+}
+
+// NewJWK describes the RSA or ECDSA public key as a JWK.
+func NewJWK(kid string, key crypto.PublicKey) (*JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			KeyID:   kid,
+			Use:     "sig",
+			N:       encodeBigInt(k.N),
+			E:       encodeBigInt(big.NewInt(int64(k.E))),
+		}, nil
+	case *ecdsa.PublicKey:
+		return &JWK{
+			KeyType: "EC",
+			KeyID:   kid,
+			Use:     "sig",
+			Curve:   k.Curve.Params().Name,
+			X:       encodeBigInt(k.X),
+			Y:       encodeBigInt(k.Y),
+		}, nil
+	default:
+		return nil, ErrKeyInvalid
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, ErrKeyInvalid
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
@@ -0,0 +1,41 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+)
+
+// Option configures the client.
+type Option func(*Client) error
+
+var ErrScopesInvalid = errors.New("scopes must include openid")
+
+// WithScopes requests the scopes instead of the default ones.
+func WithScopes(scopes ...string) Option {
+	return func(c *Client) error {
+		for _, s := range scopes {
+			if s == "openid" {
+				c.scopes = scopes
+				return nil
+			}
+		}
+
+		return ErrScopesInvalid
+	}
+}
+
+// WithTimeout limits how long a single request to the provider may take.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.client.Timeout = timeout
+		return nil
+	}
+}
+
+// WithClock makes the client validate tokens against the given time.
+func WithClock(now func() time.Time) Option {
+	return func(c *Client) error {
+		c.now = now
+		return nil
+	}
+}
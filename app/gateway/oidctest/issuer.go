@@ -0,0 +1,301 @@
+// Package oidctest provides a stub OpenID Connect issuer,
+// served over HTTP, for use in integration tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+)
+
+type (
+	// Issuer signs users in without asking them anything: the authorization
+	// endpoint redirects straight back with a code for the configured claims.
+	Issuer struct {
+		*httptest.Server
+
+		ClientID     string
+		ClientSecret string
+
+		key *rsa.PrivateKey
+		kid string
+
+		mu     sync.Mutex
+		claims map[string]interface{}
+		tamper Tamper
+		grants map[string]*grant
+	}
+
+	// Tamper makes the issuer hand out invalid ID tokens,
+	// to check that they are rejected.
+	Tamper struct {
+		Signature bool
+		Nonce     bool
+		Audience  bool
+		Issuer    bool
+		Expired   bool
+	}
+
+	grant struct {
+		redirectURI   string
+		nonce         string
+		codeChallenge string
+		claims        map[string]interface{}
+	}
+)
+
+const (
+	authorizePath = "/authorize"
+	tokenPath     = "/token"
+	jwksPath      = "/jwks"
+	kid           = "oidctest"
+	tokenLifetime = time.Hour
+)
+
+// NewIssuer starts an issuer for the client. The client is
+// public, authenticated by PKCE alone, if the secret is empty.
+func NewIssuer(clientID, clientSecret string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	i := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          kid,
+		claims:       map[string]interface{}{"sub": "upstream-subject"},
+		grants:       make(map[string]*grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc(authorizePath, i.authorize)
+	mux.HandleFunc(tokenPath, i.token)
+	mux.HandleFunc(jwksPath, i.jwks)
+
+	i.Server = httptest.NewServer(mux)
+
+	return i
+}
+
+// SetClaims sets the claims of the ID tokens issued from now on.
+func (i *Issuer) SetClaims(claims map[string]interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.claims = claims
+}
+
+// SetTamper makes the ID tokens issued from now on invalid as described.
+func (i *Issuer) SetTamper(t Tamper) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.tamper = t
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &oidc.Discovery{
+		Issuer:                i.URL,
+		AuthorizationEndpoint: i.URL + authorizePath,
+		TokenEndpoint:         i.URL + tokenPath,
+		JWKSURI:               i.URL + jwksPath,
+	})
+}
+
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case q.Get("client_id") != i.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code",
+		q.Get("code_challenge_method") != "S256",
+		q.Get("code_challenge") == "":
+		redirectError(w, r, redirectURI, q.Get("state"), "invalid_request")
+		return
+	}
+
+	code := randomString()
+
+	i.mu.Lock()
+	i.grants[code] = &grant{
+		redirectURI:   redirectURI.String(),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		claims:        i.claims,
+	}
+	i.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	if !i.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	i.mu.Lock()
+	g, ok := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	tamper := i.tamper
+	i.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	case !ok,
+		g.redirectURI != r.PostForm.Get("redirect_uri"),
+		g.codeChallenge != oidc.CodeChallenge(r.PostForm.Get("code_verifier")):
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &oidc.Token{
+		AccessToken: randomString(),
+		TokenType:   "Bearer",
+		IDToken:     i.sign(g, tamper),
+		ExpiresIn:   int64(tokenLifetime / time.Second),
+	})
+}
+
+func (i *Issuer) authenticateClient(r *http.Request) bool {
+	if i.ClientSecret == "" {
+		return r.PostForm.Get("client_id") == i.ClientID
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+
+	return id == i.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(i.ClientSecret)) == 1
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, _ *http.Request) {
+	k, err := oidc.NewJWK(i.kid, &i.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &oidc.JWKS{Keys: []oidc.JWK{*k}})
+}
+
+func (i *Issuer) sign(g *grant, t Tamper) string {
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		"iss":       i.URL,
+		"aud":       i.ClientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(tokenLifetime).Unix(),
+		"auth_time": now.Unix(),
+		"nonce":     g.nonce,
+	}
+
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+
+	if t.Nonce {
+		claims["nonce"] = randomString()
+	}
+
+	if t.Audience {
+		claims["aud"] = "someone-else"
+	}
+
+	if t.Issuer {
+		claims["iss"] = "https://issuer.invalid"
+	}
+
+	if t.Expired {
+		claims["iat"] = now.Add(-2 * tokenLifetime).Unix()
+		claims["exp"] = now.Add(-tokenLifetime).Unix()
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": i.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	if t.Signature {
+		signature[0] ^= 0xff
+	}
+
+	return signed + "." + encode(signature)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI *url.URL, state, code string) {
+	v := redirectURI.Query()
+	v.Set("error", code)
+	v.Set("state", state)
+	redirectURI.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	s, err := oidc.RandomString()
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
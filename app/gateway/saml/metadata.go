@@ -0,0 +1,113 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// IdentityProvider is what the service provider has to know
+	// about the identity provider, usually read from its metadata.
+	IdentityProvider struct {
+		EntityID string
+		// SSOURL is the single sign-on service of the HTTP-Redirect binding.
+		SSOURL string
+		// Certificates are the ones assertions may be signed with.
+		Certificates []*x509.Certificate
+	}
+
+	entityDescriptor struct {
+		XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+		EntityID         string   `xml:"entityID,attr"`
+		IDPSSODescriptor []struct {
+			KeyDescriptors []struct {
+				Use          string   `xml:"use,attr"`
+				Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+			SingleSignOnServices []struct {
+				Binding  string `xml:"Binding,attr"`
+				Location string `xml:"Location,attr"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+	}
+)
+
+var (
+	ErrMetadataInvalid     = errors.New("identity provider metadata is invalid")
+	ErrSSOURLMissing       = errors.New("identity provider has no single sign-on service of the HTTP-Redirect binding")
+	ErrCertificatesMissing = errors.New("identity provider has no signing certificates")
+)
+
+// ParseMetadata reads the entity descriptor of an identity provider.
+func ParseMetadata(data []byte) (*IdentityProvider, error) {
+	var ed entityDescriptor
+	if err := xml.Unmarshal(data, &ed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadataInvalid, err)
+	}
+
+	if len(ed.IDPSSODescriptor) == 0 {
+		return nil, fmt.Errorf("%w: identity provider descriptor is missing", ErrMetadataInvalid)
+	}
+
+	idp := &IdentityProvider{EntityID: ed.EntityID}
+
+	for _, d := range ed.IDPSSODescriptor {
+		for _, s := range d.SingleSignOnServices {
+			if s.Binding == BindingHTTPRedirect && idp.SSOURL == "" {
+				idp.SSOURL = s.Location
+			}
+		}
+
+		for _, k := range d.KeyDescriptors {
+			if k.Use != "" && k.Use != "signing" {
+				continue
+			}
+
+			for _, c := range k.Certificates {
+				cert, err := parseCertificate(c)
+				if err != nil {
+					return nil, err
+				}
+
+				idp.Certificates = append(idp.Certificates, cert)
+			}
+		}
+	}
+
+	if err := idp.validate(); err != nil {
+		return nil, err
+	}
+
+	return idp, nil
+}
+
+func (idp *IdentityProvider) validate() error {
+	switch {
+	case idp == nil || idp.EntityID == "":
+		return fmt.Errorf("%w: entity id is missing", ErrMetadataInvalid)
+	case idp.SSOURL == "":
+		return ErrSSOURLMissing
+	case len(idp.Certificates) == 0:
+		return ErrCertificatesMissing
+	}
+
+	return nil
+}
+
+func parseCertificate(encoded string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: certificate is not base64: %v", ErrMetadataInvalid, err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadataInvalid, err)
+	}
+
+	return cert, nil
+}
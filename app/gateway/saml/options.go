@@ -0,0 +1,14 @@
+package saml
+
+import "time"
+
+// Option configures the service provider.
+type Option func(*ServiceProvider) error
+
+// WithClock makes the service provider validate assertions against the given time.
+func WithClock(now func() time.Time) Option {
+	return func(sp *ServiceProvider) error {
+		sp.now = now
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package saml
+
+import (
+	"sync"
+	"time"
+)
+
+// replayCache remembers the assertions consumed so far until they
+// expire, so that each one may only be used once. It is kept in
+// memory, as the flow cookie already ties a response to the browser
+// the sign-in was started in.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// observe records the assertion until it expires, reporting
+// whether it has not been seen before.
+func (c *replayCache) observe(id string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.seen {
+		if now.After(e) {
+			delete(c.seen, k)
+		}
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+
+	c.seen[id] = expires
+
+	return true
+}
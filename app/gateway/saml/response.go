@@ -0,0 +1,402 @@
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+type (
+	// Assertion holds what the identity provider asserted about the
+	// user, once the response carrying it has been validated.
+	Assertion struct {
+		ID           string
+		NameID       string
+		NameIDFormat string
+		SessionIndex string
+		AuthnInstant time.Time
+		// AuthnContext is the class reference of how the user authenticated.
+		AuthnContext string
+		// Attributes are keyed by name, as well as by friendly name if there is one.
+		Attributes map[string][]string
+	}
+
+	response struct {
+		XMLName      xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+		ID           string    `xml:"ID,attr"`
+		InResponseTo string    `xml:"InResponseTo,attr"`
+		Destination  string    `xml:"Destination,attr"`
+		IssueInstant time.Time `xml:"IssueInstant,attr"`
+		Issuer       string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Status       struct {
+			StatusCode statusCode `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+	}
+
+	statusCode struct {
+		Value      string      `xml:"Value,attr"`
+		StatusCode *statusCode `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+	}
+
+	assertion struct {
+		XMLName      xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+		ID           string    `xml:"ID,attr"`
+		IssueInstant time.Time `xml:"IssueInstant,attr"`
+		Issuer       string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Subject      struct {
+			NameID struct {
+				Format string `xml:"Format,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+			SubjectConfirmations []struct {
+				Method string `xml:"Method,attr"`
+				Data   struct {
+					NotBefore    time.Time `xml:"NotBefore,attr"`
+					NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+					Recipient    string    `xml:"Recipient,attr"`
+					InResponseTo string    `xml:"InResponseTo,attr"`
+				} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+		Conditions *struct {
+			NotBefore            time.Time `xml:"NotBefore,attr"`
+			NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+			AudienceRestrictions []struct {
+				Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+		AuthnStatements []struct {
+			AuthnInstant time.Time `xml:"AuthnInstant,attr"`
+			SessionIndex string    `xml:"SessionIndex,attr"`
+			ClassRef     string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnContext>AuthnContextClassRef"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnStatement"`
+		AttributeStatements []struct {
+			Attributes []struct {
+				Name         string   `xml:"Name,attr"`
+				FriendlyName string   `xml:"FriendlyName,attr"`
+				Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement"`
+	}
+)
+
+const (
+	StatusSuccess     = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	StatusAuthnFailed = "urn:oasis:names:tc:SAML:2.0:status:AuthnFailed"
+	StatusDenied      = "urn:oasis:names:tc:SAML:2.0:status:RequestDenied"
+	StatusNoPassive   = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	namespaceSignature = "http://www.w3.org/2000/09/xmldsig#"
+
+	// leeway tolerates clock skew between the identity provider and this service.
+	leeway = 90 * time.Second
+	// maxResponseSize limits the decoded response read from the form.
+	maxResponseSize = 1 << 20
+)
+
+var (
+	ErrResponseMalformed     = errors.New("response is malformed")
+	ErrSignatureMissing      = errors.New("assertion is not signed")
+	ErrSignatureInvalid      = errors.New("signature is invalid")
+	ErrAssertionEncrypted    = errors.New("encrypted assertions are not supported")
+	ErrIssuerMismatch        = errors.New("issuer does not match")
+	ErrDestinationMismatch   = errors.New("response destination does not match")
+	ErrInResponseToMismatch  = errors.New("response is not to the request in progress")
+	ErrAuthnFailed           = errors.New("identity provider did not authenticate the user")
+	ErrStatus                = errors.New("identity provider returned an error status")
+	ErrAudienceMismatch      = errors.New("assertion audience does not match")
+	ErrAssertionExpired      = errors.New("assertion has expired")
+	ErrAssertionNotYetValid  = errors.New("assertion is not yet valid")
+	ErrSubjectMissing        = errors.New("assertion subject is missing")
+	ErrSubjectNotConfirmed   = errors.New("assertion subject can not be confirmed")
+	ErrAuthnStatementMissing = errors.New("assertion has no authentication statement")
+	ErrAssertionReplayed     = errors.New("assertion was already used")
+)
+
+// ParseResponse validates the base64 encoded response of the HTTP-POST
+// binding, which has to answer the request of the given id, and returns
+// its assertion. Unsolicited responses of logins initiated by the identity
+// provider are never accepted. Either the response or the assertion has to
+// be signed, and only what is covered by a signature is looked at.
+func (sp *ServiceProvider) ParseResponse(encoded, requestID string) (*Assertion, error) {
+	if base64.StdEncoding.DecodedLen(len(encoded)) > maxResponseSize {
+		return nil, fmt.Errorf("%w: too large", ErrResponseMalformed)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	el := doc.Root()
+	if el == nil || el.Tag != "Response" || el.NamespaceURI() != NamespaceProtocol {
+		return nil, fmt.Errorf("%w: not a response", ErrResponseMalformed)
+	}
+
+	signed := childElement(el, namespaceSignature, "Signature") != nil
+	if signed {
+		if el, err = sp.validateSignature(el); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp response
+	if err := unmarshal(el, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := sp.validateResponse(&resp, requestID, signed); err != nil {
+		return nil, err
+	}
+
+	assertionEl, err := sp.assertionElement(el, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	var a assertion
+	if err := unmarshal(assertionEl, &a); err != nil {
+		return nil, err
+	}
+
+	expires, err := sp.validateAssertion(&a, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sp.replays.observe(a.ID, expires.Add(leeway), sp.now()) {
+		return nil, ErrAssertionReplayed
+	}
+
+	return a.result(), nil
+}
+
+// assertionElement returns the single plain assertion of the response,
+// validating its signature, which is required if the response is not signed.
+func (sp *ServiceProvider) assertionElement(resp *etree.Element, responseSigned bool) (*etree.Element, error) {
+	var found []*etree.Element
+
+	for _, c := range resp.ChildElements() {
+		if c.NamespaceURI() != NamespaceAssertion {
+			continue
+		}
+
+		switch c.Tag {
+		case "EncryptedAssertion":
+			return nil, ErrAssertionEncrypted
+		case "Assertion":
+			found = append(found, c)
+		}
+	}
+
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%w: expected a single assertion, got %d", ErrResponseMalformed, len(found))
+	}
+
+	el := found[0]
+
+	if childElement(el, namespaceSignature, "Signature") == nil {
+		if !responseSigned {
+			return nil, ErrSignatureMissing
+		}
+
+		return el, nil
+	}
+
+	// The assertion is validated on its own, so it has to carry
+	// the namespaces it inherits from the response along with it.
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	detached, err := etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	return sp.validateSignature(detached)
+}
+
+// validateSignature checks the enveloped signature of the element and
+// returns the element as it was signed, which is what is used from then on.
+func (sp *ServiceProvider) validateSignature(el *etree.Element) (*etree.Element, error) {
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.idp.Certificates})
+	ctx.Clock = dsig.NewFakeClockAt(sp.now())
+
+	validated, err := ctx.Validate(el)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+
+	return validated, nil
+}
+
+// validateResponse checks the response itself. Its destination is
+// optional, unless the response is signed, in which case it is what
+// keeps the response from being posted to another service provider.
+func (sp *ServiceProvider) validateResponse(r *response, requestID string, signed bool) error {
+	switch {
+	case r.Issuer != "" && r.Issuer != sp.idp.EntityID:
+		return fmt.Errorf("%w: %q", ErrIssuerMismatch, r.Issuer)
+	case signed && r.Destination == "":
+		return fmt.Errorf("%w: missing from the signed response", ErrDestinationMismatch)
+	case r.Destination != "" && r.Destination != sp.acsURL:
+		return fmt.Errorf("%w: %q", ErrDestinationMismatch, r.Destination)
+	case requestID == "" || r.InResponseTo != requestID:
+		return ErrInResponseToMismatch
+	}
+
+	if code := r.Status.StatusCode; code.Value != StatusSuccess {
+		if code.StatusCode != nil {
+			switch code.StatusCode.Value {
+			case StatusAuthnFailed, StatusDenied, StatusNoPassive:
+				return fmt.Errorf("%w: %s", ErrAuthnFailed, code.StatusCode.Value)
+			}
+		}
+
+		return fmt.Errorf("%w: %s", ErrStatus, code.Value)
+	}
+
+	return nil
+}
+
+// validateAssertion checks that the assertion is about a user who has just
+// authenticated at this service provider's request and returns when it
+// expires, which is how long it has to be guarded against replays.
+func (sp *ServiceProvider) validateAssertion(a *assertion, requestID string) (time.Time, error) {
+	now := sp.now()
+
+	switch {
+	case a.ID == "":
+		return time.Time{}, fmt.Errorf("%w: assertion id is missing", ErrResponseMalformed)
+	case a.Issuer != sp.idp.EntityID:
+		return time.Time{}, fmt.Errorf("%w: %q", ErrIssuerMismatch, a.Issuer)
+	case strings.TrimSpace(a.Subject.NameID.Value) == "":
+		return time.Time{}, ErrSubjectMissing
+	case len(a.AuthnStatements) == 0:
+		return time.Time{}, ErrAuthnStatementMissing
+	case a.Conditions == nil || len(a.Conditions.AudienceRestrictions) == 0:
+		return time.Time{}, fmt.Errorf("%w: audience restriction is missing", ErrAudienceMismatch)
+	}
+
+	c := a.Conditions
+
+	if !c.NotBefore.IsZero() && now.Add(leeway).Before(c.NotBefore) {
+		return time.Time{}, ErrAssertionNotYetValid
+	}
+
+	if !c.NotOnOrAfter.IsZero() && !now.Add(-leeway).Before(c.NotOnOrAfter) {
+		return time.Time{}, ErrAssertionExpired
+	}
+
+	// Each of the audience restrictions has to be met.
+	for _, r := range c.AudienceRestrictions {
+		if !contains(r.Audiences, sp.entityID) {
+			return time.Time{}, ErrAudienceMismatch
+		}
+	}
+
+	for _, sc := range a.Subject.SubjectConfirmations {
+		d := sc.Data
+
+		if sc.Method != confirmationBearer ||
+			d.Recipient != sp.acsURL ||
+			d.InResponseTo != requestID ||
+			!d.NotBefore.IsZero() ||
+			d.NotOnOrAfter.IsZero() ||
+			!now.Add(-leeway).Before(d.NotOnOrAfter) {
+			continue
+		}
+
+		expires := d.NotOnOrAfter
+		if !c.NotOnOrAfter.IsZero() && c.NotOnOrAfter.Before(expires) {
+			expires = c.NotOnOrAfter
+		}
+
+		return expires, nil
+	}
+
+	return time.Time{}, ErrSubjectNotConfirmed
+}
+
+func (a *assertion) result() *Assertion {
+	s := a.AuthnStatements[0]
+
+	r := &Assertion{
+		ID:           a.ID,
+		NameID:       strings.TrimSpace(a.Subject.NameID.Value),
+		NameIDFormat: a.Subject.NameID.Format,
+		SessionIndex: s.SessionIndex,
+		AuthnInstant: s.AuthnInstant,
+		AuthnContext: s.ClassRef,
+		Attributes:   make(map[string][]string),
+	}
+
+	for _, st := range a.AttributeStatements {
+		for _, attr := range st.Attributes {
+			r.Attributes[attr.Name] = append(r.Attributes[attr.Name], attr.Values...)
+
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				r.Attributes[attr.FriendlyName] = append(r.Attributes[attr.FriendlyName], attr.Values...)
+			}
+		}
+	}
+
+	return r
+}
+
+// Attribute returns the first value of the attribute.
+func (a *Assertion) Attribute(name string) string {
+	if v := a.Attributes[name]; len(v) != 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func unmarshal(el *etree.Element, v interface{}) error {
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	if err := etreeutils.NSUnmarshalElement(ctx, el, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrResponseMalformed, err)
+	}
+
+	return nil
+}
+
+func childElement(el *etree.Element, namespace, tag string) *etree.Element {
+	for _, c := range el.ChildElements() {
+		if c.Tag == tag && c.NamespaceURI() == namespace {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,237 @@
+package saml_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/mpraski/identity-provider/app/gateway/saml"
+	"github.com/mpraski/identity-provider/app/gateway/samltest"
+)
+
+const (
+	entityID = "https://idp.test/saml"
+	acsURL   = "https://idp.test/authentication/login/saml/corp/acs"
+)
+
+var responsePattern = regexp.MustCompile(`name="SAMLResponse" value="([^"]+)"`)
+
+func TestParseResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper samltest.Tamper
+		// modify changes the response after it was issued.
+		modify func(t *testing.T, resp *etree.Element)
+		// requestID replaces the id of the request the response answers.
+		requestID *string
+		err       error
+	}{
+		{
+			name: "accepted",
+		},
+		{
+			name:   "unsigned",
+			tamper: samltest.Tamper{Unsigned: true},
+			err:    saml.ErrSignatureMissing,
+		},
+		{
+			name:   "signature tampered",
+			tamper: samltest.Tamper{Signature: true},
+			err:    saml.ErrSignatureInvalid,
+		},
+		{
+			name:   "assertion duplicated",
+			modify: duplicateAssertion,
+			err:    saml.ErrResponseMalformed,
+		},
+		{
+			name:   "assertion wrapped",
+			modify: wrapAssertion,
+			err:    saml.ErrSignatureInvalid,
+		},
+		{
+			name:   "audience of someone else",
+			tamper: samltest.Tamper{Audience: true},
+			err:    saml.ErrAudienceMismatch,
+		},
+		{
+			name:   "recipient of someone else",
+			tamper: samltest.Tamper{Recipient: true},
+			err:    saml.ErrSubjectNotConfirmed,
+		},
+		{
+			name:   "expired",
+			tamper: samltest.Tamper{Expired: true},
+			err:    saml.ErrAssertionExpired,
+		},
+		{
+			name:   "denied",
+			tamper: samltest.Tamper{Denied: true},
+			err:    saml.ErrAuthnFailed,
+		},
+		{
+			name:      "answering another request",
+			requestID: stringPtr("_another-request"),
+			err:       saml.ErrInResponseToMismatch,
+		},
+		{
+			name:      "unsolicited",
+			requestID: stringPtr(""),
+			err:       saml.ErrInResponseToMismatch,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idp, sp := newServiceProvider(t)
+			idp.SetTamper(c.tamper)
+
+			encoded, requestID := issueResponse(t, idp, sp)
+
+			if c.modify != nil {
+				encoded = modifyResponse(t, encoded, c.modify)
+			}
+
+			if c.requestID != nil {
+				requestID = *c.requestID
+			}
+
+			a, err := sp.ParseResponse(encoded, requestID)
+
+			switch {
+			case c.err != nil && !errors.Is(err, c.err):
+				t.Fatalf("parsing failed with %v, want %v", err, c.err)
+			case c.err == nil && err != nil:
+				t.Fatalf("parsing failed: %v", err)
+			case c.err == nil && (a.NameID != "jdoe" || a.Attribute("email") != "jdoe@example.com"):
+				t.Fatalf("assertion = %+v", a)
+			}
+		})
+	}
+}
+
+func TestParseResponseReplayed(t *testing.T) {
+	idp, sp := newServiceProvider(t)
+
+	encoded, requestID := issueResponse(t, idp, sp)
+
+	if _, err := sp.ParseResponse(encoded, requestID); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+
+	if _, err := sp.ParseResponse(encoded, requestID); !errors.Is(err, saml.ErrAssertionReplayed) {
+		t.Fatalf("parsing again failed with %v, want %v", err, saml.ErrAssertionReplayed)
+	}
+}
+
+func newServiceProvider(t *testing.T) (*samltest.IdentityProvider, *saml.ServiceProvider) {
+	t.Helper()
+
+	idp := samltest.NewIdentityProvider()
+	t.Cleanup(idp.Close)
+
+	idp.SetUser("jdoe", map[string][]string{"email": {"jdoe@example.com"}})
+
+	sp, err := saml.New(entityID, acsURL, idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idp, sp
+}
+
+// issueResponse sends an authentication request to the identity provider
+// and returns the response it posts back, along with the id of the request.
+func issueResponse(t *testing.T, idp *samltest.IdentityProvider, sp *saml.ServiceProvider) (encoded, requestID string) {
+	t.Helper()
+
+	u, requestID, err := sp.AuthnRequestURL("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := idp.Client().Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := responsePattern.FindSubmatch(body)
+	if resp.StatusCode != http.StatusOK || m == nil {
+		t.Fatalf("identity provider answered with %d: %s", resp.StatusCode, body)
+	}
+
+	return html.UnescapeString(string(m[1])), requestID
+}
+
+func modifyResponse(t *testing.T, encoded string, modify func(*testing.T, *etree.Element)) string {
+	t.Helper()
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	modify(t, doc.Root())
+
+	raw, err = doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// duplicateAssertion adds a second copy of the signed assertion, which
+// leaves it open which of them the signature was meant to cover.
+func duplicateAssertion(t *testing.T, resp *etree.Element) {
+	t.Helper()
+
+	resp.AddChild(signedAssertion(t, resp).Copy())
+}
+
+// wrapAssertion replaces the signed assertion with a forged one about
+// someone else, which carries the original along to pass as signed.
+func wrapAssertion(t *testing.T, resp *etree.Element) {
+	t.Helper()
+
+	original := signedAssertion(t, resp)
+
+	forged := original.Copy()
+	forged.FindElement("./Subject/NameID").SetText("admin")
+	forged.FindElement("./Signature").AddChild(original.Copy())
+
+	resp.RemoveChild(original)
+	resp.AddChild(forged)
+}
+
+func signedAssertion(t *testing.T, resp *etree.Element) *etree.Element {
+	t.Helper()
+
+	a := resp.FindElement("./Assertion")
+	if a == nil || a.FindElement("./Signature") == nil {
+		t.Fatal("response has no signed assertion")
+	}
+
+	return a
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,182 @@
+// Package saml is a SAML 2.0 service provider of the Web Browser SSO
+// profile. Authentication requests are sent with the HTTP-Redirect
+// binding, while responses are consumed with the HTTP-POST binding.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// ServiceProvider signs users in at a single identity provider.
+type ServiceProvider struct {
+	entityID string
+	acsURL   string
+	idp      *IdentityProvider
+	now      func() time.Time
+	replays  *replayCache
+}
+
+const (
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+
+	timeFormat = "2006-01-02T15:04:05Z"
+)
+
+var (
+	ErrEntityIDMissing = errors.New("entity id is missing")
+	ErrACSURLInvalid   = errors.New("assertion consumer service URL must be an absolute http or https URL")
+)
+
+// New creates a service provider identified by the entity id, which
+// receives responses of the identity provider at the ACS URL.
+func New(entityID, acsURL string, idp *IdentityProvider, opts ...Option) (*ServiceProvider, error) {
+	if entityID == "" {
+		return nil, ErrEntityIDMissing
+	}
+
+	u, err := url.Parse(acsURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrACSURLInvalid
+	}
+
+	if err := idp.validate(); err != nil {
+		return nil, err
+	}
+
+	sp := &ServiceProvider{
+		entityID: entityID,
+		acsURL:   acsURL,
+		idp:      idp,
+		now:      time.Now,
+		replays:  newReplayCache(),
+	}
+
+	for _, o := range opts {
+		if err := o(sp); err != nil {
+			return nil, err
+		}
+	}
+
+	return sp, nil
+}
+
+// Metadata describes the service provider to the identity provider.
+// Assertions are required to be signed, while requests are not.
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	ed := doc.CreateElement("md:EntityDescriptor")
+	ed.CreateAttr("xmlns:md", NamespaceMetadata)
+	ed.CreateAttr("entityID", sp.entityID)
+
+	sso := ed.CreateElement("md:SPSSODescriptor")
+	sso.CreateAttr("AuthnRequestsSigned", "false")
+	sso.CreateAttr("WantAssertionsSigned", "true")
+	sso.CreateAttr("protocolSupportEnumeration", NamespaceProtocol)
+	sso.CreateElement("md:NameIDFormat").SetText(NameIDFormatUnspecified)
+
+	acs := sso.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", BindingHTTPPost)
+	acs.CreateAttr("Location", sp.acsURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+
+	return doc.WriteToBytes()
+}
+
+// AuthnRequestURL returns where to send the user to authenticate,
+// along with the id of the request, which the response has to
+// refer to. The relay state is given back with the response.
+func (sp *ServiceProvider) AuthnRequestURL(relayState string) (redirectTo, requestID string, err error) {
+	if requestID, err = newID(); err != nil {
+		return "", "", err
+	}
+
+	req := etree.NewElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	req.CreateAttr("xmlns:saml", NamespaceAssertion)
+	req.CreateAttr("ID", requestID)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", sp.now().UTC().Format(timeFormat))
+	req.CreateAttr("Destination", sp.idp.SSOURL)
+	req.CreateAttr("ProtocolBinding", BindingHTTPPost)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.acsURL)
+	req.CreateElement("saml:Issuer").SetText(sp.entityID)
+
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", NameIDFormatUnspecified)
+	policy.CreateAttr("AllowCreate", "true")
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req)
+
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode authentication request: %w", err)
+	}
+
+	// The HTTP-Redirect binding deflates the request before encoding it.
+	var deflated bytes.Buffer
+
+	w, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := w.Write(raw); err != nil {
+		return "", "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+
+	u, err := url.Parse(sp.idp.SSOURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse single sign-on URL: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), requestID, nil
+}
+
+// newID returns a random identifier. It starts with an underscore,
+// as identifiers have to be XML names, which may not start with a digit.
+func newID() (string, error) {
+	b := make([]byte, 20)
+
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	return "_" + hex.EncodeToString(b), nil
+}
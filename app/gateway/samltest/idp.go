@@ -0,0 +1,358 @@
+// Package samltest provides a stub SAML 2.0 identity provider,
+// served over HTTP, for use in integration tests.
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/mpraski/identity-provider/app/gateway/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+type (
+	// IdentityProvider signs users in without asking them anything: the
+	// single sign-on service answers straight away with a page posting
+	// a signed assertion about the configured user back to the ACS.
+	IdentityProvider struct {
+		*httptest.Server
+
+		key  *rsa.PrivateKey
+		cert *x509.Certificate
+
+		mu         sync.Mutex
+		nameID     string
+		attributes map[string][]string
+		tamper     Tamper
+	}
+
+	// Tamper makes the identity provider hand out invalid
+	// responses, to check that they are rejected.
+	Tamper struct {
+		Signature bool
+		Unsigned  bool
+		Audience  bool
+		Recipient bool
+		Expired   bool
+		Denied    bool
+	}
+
+	authnRequest struct {
+		ID                          string `xml:"ID,attr"`
+		AssertionConsumerServiceURL string `xml:"AssertionConsumerServiceURL,attr"`
+		Issuer                      string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	}
+)
+
+const (
+	metadataPath = "/metadata"
+	ssoPath      = "/sso"
+	lifetime     = 5 * time.Minute
+	timeFormat   = "2006-01-02T15:04:05Z"
+)
+
+var postForm = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="SAMLResponse" value="{{.Response}}">
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+</form>
+</body>
+</html>
+`))
+
+// NewIdentityProvider starts an identity provider with a fresh signing certificate.
+func NewIdentityProvider() *IdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "samltest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	i := &IdentityProvider{
+		key:        key,
+		cert:       cert,
+		nameID:     "upstream-subject",
+		attributes: make(map[string][]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metadataPath, i.metadata)
+	mux.HandleFunc(ssoPath, i.sso)
+
+	i.Server = httptest.NewServer(mux)
+
+	return i
+}
+
+// EntityID identifies the identity provider, as the URL of its metadata.
+func (i *IdentityProvider) EntityID() string {
+	return i.URL + metadataPath
+}
+
+// Metadata describes the identity provider to the service provider.
+func (i *IdentityProvider) Metadata() *saml.IdentityProvider {
+	return &saml.IdentityProvider{
+		EntityID:     i.EntityID(),
+		SSOURL:       i.URL + ssoPath,
+		Certificates: []*x509.Certificate{i.cert},
+	}
+}
+
+// SetUser sets who the assertions issued from now on are about.
+func (i *IdentityProvider) SetUser(nameID string, attributes map[string][]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.nameID = nameID
+	i.attributes = attributes
+}
+
+// SetTamper makes the responses issued from now on invalid as described.
+func (i *IdentityProvider) SetTamper(t Tamper) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.tamper = t
+}
+
+func (i *IdentityProvider) metadata(w http.ResponseWriter, _ *http.Request) {
+	doc := etree.NewDocument()
+
+	ed := doc.CreateElement("md:EntityDescriptor")
+	ed.CreateAttr("xmlns:md", saml.NamespaceMetadata)
+	ed.CreateAttr("xmlns:ds", "http://www.w3.org/2000/09/xmldsig#")
+	ed.CreateAttr("entityID", i.EntityID())
+
+	sso := ed.CreateElement("md:IDPSSODescriptor")
+	sso.CreateAttr("protocolSupportEnumeration", saml.NamespaceProtocol)
+
+	kd := sso.CreateElement("md:KeyDescriptor")
+	kd.CreateAttr("use", "signing")
+	kd.CreateElement("ds:KeyInfo").CreateElement("ds:X509Data").CreateElement("ds:X509Certificate").
+		SetText(base64.StdEncoding.EncodeToString(i.cert.Raw))
+
+	s := sso.CreateElement("md:SingleSignOnService")
+	s.CreateAttr("Binding", saml.BindingHTTPRedirect)
+	s.CreateAttr("Location", i.URL+ssoPath)
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = doc.WriteTo(w)
+}
+
+func (i *IdentityProvider) sso(w http.ResponseWriter, r *http.Request) {
+	deflated, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("SAMLRequest"))
+	if err != nil {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	var req authnRequest
+	if err := xml.Unmarshal(raw, &req); err != nil || req.ID == "" || req.AssertionConsumerServiceURL == "" {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	i.mu.Lock()
+	nameID, attributes, tamper := i.nameID, i.attributes, i.tamper
+	i.mu.Unlock()
+
+	resp, err := i.response(&req, nameID, attributes, tamper)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = postForm.Execute(w, map[string]string{
+		"URL":        req.AssertionConsumerServiceURL,
+		"Response":   base64.StdEncoding.EncodeToString(resp),
+		"RelayState": r.URL.Query().Get("RelayState"),
+	})
+}
+
+func (i *IdentityProvider) response(req *authnRequest, nameID string, attributes map[string][]string, t Tamper) ([]byte, error) {
+	now := time.Now().UTC()
+
+	resp := etree.NewElement("samlp:Response")
+	resp.CreateAttr("xmlns:samlp", saml.NamespaceProtocol)
+	resp.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	resp.CreateAttr("ID", newID())
+	resp.CreateAttr("Version", "2.0")
+	resp.CreateAttr("IssueInstant", now.Format(timeFormat))
+	resp.CreateAttr("Destination", req.AssertionConsumerServiceURL)
+	resp.CreateAttr("InResponseTo", req.ID)
+	resp.CreateElement("saml:Issuer").SetText(i.EntityID())
+
+	status := saml.StatusSuccess
+	if t.Denied {
+		status = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	}
+
+	code := resp.CreateElement("samlp:Status").CreateElement("samlp:StatusCode")
+	code.CreateAttr("Value", status)
+
+	if t.Denied {
+		code.CreateElement("samlp:StatusCode").CreateAttr("Value", saml.StatusDenied)
+
+		return serialize(resp)
+	}
+
+	a, err := i.assertion(req, nameID, attributes, t, now)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.AddChild(a)
+
+	return serialize(resp)
+}
+
+func (i *IdentityProvider) assertion(
+	req *authnRequest,
+	nameID string,
+	attributes map[string][]string,
+	t Tamper,
+	now time.Time,
+) (*etree.Element, error) {
+	var (
+		notBefore    = now.Add(-time.Minute)
+		notOnOrAfter = now.Add(lifetime)
+		audience     = req.Issuer
+		recipient    = req.AssertionConsumerServiceURL
+	)
+
+	if t.Expired {
+		notBefore = now.Add(-2 * time.Hour)
+		notOnOrAfter = now.Add(-time.Hour)
+	}
+
+	if t.Audience {
+		audience = "https://someone-else.invalid"
+	}
+
+	if t.Recipient {
+		recipient = "https://someone-else.invalid/acs"
+	}
+
+	// The assertion declares its own namespace, so that it is signed the
+	// same way whether or not it is looked at within the response.
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	a.CreateAttr("ID", newID())
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", now.Format(timeFormat))
+	a.CreateElement("saml:Issuer").SetText(i.EntityID())
+
+	subject := a.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText(nameID)
+
+	sc := subject.CreateElement("saml:SubjectConfirmation")
+	sc.CreateAttr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+
+	scd := sc.CreateElement("saml:SubjectConfirmationData")
+	scd.CreateAttr("NotOnOrAfter", notOnOrAfter.Format(timeFormat))
+	scd.CreateAttr("Recipient", recipient)
+	scd.CreateAttr("InResponseTo", req.ID)
+
+	conditions := a.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", notBefore.Format(timeFormat))
+	conditions.CreateAttr("NotOnOrAfter", notOnOrAfter.Format(timeFormat))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(audience)
+
+	as := a.CreateElement("saml:AuthnStatement")
+	as.CreateAttr("AuthnInstant", now.Format(timeFormat))
+	as.CreateAttr("SessionIndex", newID())
+	as.CreateElement("saml:AuthnContext").CreateElement("saml:AuthnContextClassRef").
+		SetText("urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport")
+
+	if len(attributes) != 0 {
+		st := a.CreateElement("saml:AttributeStatement")
+
+		for name, values := range attributes {
+			attr := st.CreateElement("saml:Attribute")
+			attr.CreateAttr("Name", name)
+
+			for _, v := range values {
+				attr.CreateElement("saml:AttributeValue").SetText(v)
+			}
+		}
+	}
+
+	if t.Unsigned {
+		return a, nil
+	}
+
+	ctx, err := dsig.NewSigningContext(i.key, [][]byte{i.cert.Raw})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	signed, err := ctx.SignEnveloped(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Signature {
+		signed.FindElement("./Subject/NameID").SetText(nameID + "-tampered")
+	}
+
+	return signed, nil
+}
+
+func serialize(el *etree.Element) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+
+	return doc.WriteToBytes()
+}
+
+func newID() string {
+	b := make([]byte, 20)
+
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	return "_" + base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,607 @@
+// Package hydratest provides an in-memory stand-in for the Hydra admin API,
+// covering the login, consent and logout flows, for use in tests.
+package hydratest
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+	"github.com/ory/hydra-client-go/models"
+)
+
+type (
+	// Admin implements the login, consent and logout parts of
+	// hydraAdmin.ClientService. Calling any other method panics.
+	Admin struct {
+		hydraAdmin.ClientService
+
+		mu       sync.Mutex
+		now      func() time.Time
+		ttl      time.Duration
+		baseURL  string
+		counter  int
+		logins   map[string]*login
+		consents map[string]*consent
+		logouts  map[string]*logout
+		// session is the subject of the remembered login session
+		// of the only browser the fake Hydra knows about.
+		session  string
+		remember map[string]*models.PreviousConsentSession
+		calls    []Call
+	}
+
+	// Call records a request made to the admin API.
+	Call struct {
+		Operation string
+		Challenge string
+		Subject   string
+		Body      interface{}
+	}
+
+	Option func(*Admin)
+
+	handled struct {
+		expires    time.Time
+		redirectTo string
+	}
+
+	login struct {
+		handled
+		request *models.LoginRequest
+	}
+
+	consent struct {
+		handled
+		request *models.ConsentRequest
+	}
+
+	logout struct {
+		handled
+		request *models.LogoutRequest
+	}
+)
+
+const (
+	OpGetLoginRequest             = "GetLoginRequest"
+	OpAcceptLoginRequest          = "AcceptLoginRequest"
+	OpRejectLoginRequest          = "RejectLoginRequest"
+	OpGetConsentRequest           = "GetConsentRequest"
+	OpAcceptConsentRequest        = "AcceptConsentRequest"
+	OpRejectConsentRequest        = "RejectConsentRequest"
+	OpGetLogoutRequest            = "GetLogoutRequest"
+	OpAcceptLogoutRequest         = "AcceptLogoutRequest"
+	OpRejectLogoutRequest         = "RejectLogoutRequest"
+	OpListSubjectConsentSessions  = "ListSubjectConsentSessions"
+	OpRevokeConsentSessions       = "RevokeConsentSessions"
+	OpRevokeAuthenticationSession = "RevokeAuthenticationSession"
+
+	defaultTTL     = 10 * time.Minute
+	defaultBaseURL = "https://hydra.test"
+)
+
+var _ hydraAdmin.ClientService = (*Admin)(nil)
+
+// WithClock makes the fake use the given clock to expire challenges.
+func WithClock(now func() time.Time) Option {
+	return func(a *Admin) { a.now = now }
+}
+
+// WithTTL sets for how long challenges are valid.
+func WithTTL(ttl time.Duration) Option {
+	return func(a *Admin) { a.ttl = ttl }
+}
+
+// WithBaseURL sets the public URL of Hydra the redirects point to.
+func WithBaseURL(baseURL string) Option {
+	return func(a *Admin) { a.baseURL = baseURL }
+}
+
+func New(opts ...Option) *Admin {
+	a := &Admin{
+		now:      time.Now,
+		ttl:      defaultTTL,
+		baseURL:  defaultBaseURL,
+		logins:   make(map[string]*login),
+		consents: make(map[string]*consent),
+		logouts:  make(map[string]*logout),
+		remember: make(map[string]*models.PreviousConsentSession),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a
+}
+
+// NewLoginRequest registers a login request and returns its challenge.
+// If the browser has a remembered login session, the request is
+// marked to be skipped for its subject.
+func (a *Admin) NewLoginRequest(req *models.LoginRequest) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	challenge := a.challenge("login")
+	skip := a.session != ""
+
+	r := *req
+	r.Challenge = &challenge
+	r.Skip = &skip
+
+	if skip {
+		subject := a.session
+		r.Subject = &subject
+	} else if r.Subject == nil {
+		empty := ""
+		r.Subject = &empty
+	}
+
+	if r.RequestURL == nil {
+		u := a.baseURL + "/oauth2/auth"
+		r.RequestURL = &u
+	}
+
+	a.logins[challenge] = &login{
+		handled: handled{expires: a.now().Add(a.ttl)},
+		request: &r,
+	}
+
+	return challenge
+}
+
+// NewConsentRequest registers a consent request and returns its challenge.
+// If the subject has a remembered consent for the client covering
+// all of the requested scopes, the request is marked to be skipped.
+func (a *Admin) NewConsentRequest(req *models.ConsentRequest) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	challenge := a.challenge("consent")
+
+	r := *req
+	r.Challenge = &challenge
+
+	if prev, ok := a.remember[rememberKey(r.Subject, clientID(r.Client))]; ok && covers(prev.GrantScope, r.RequestedScope) {
+		r.Skip = true
+	}
+
+	a.consents[challenge] = &consent{
+		handled: handled{expires: a.now().Add(a.ttl)},
+		request: &r,
+	}
+
+	return challenge
+}
+
+// NewLogoutRequest registers a logout request and returns its challenge.
+func (a *Admin) NewLogoutRequest(req *models.LogoutRequest) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	challenge := a.challenge("logout")
+
+	r := *req
+	r.Challenge = challenge
+
+	a.logouts[challenge] = &logout{
+		handled: handled{expires: a.now().Add(a.ttl)},
+		request: &r,
+	}
+
+	return challenge
+}
+
+// RememberLogin gives the browser a remembered login session for the subject.
+func (a *Admin) RememberLogin(subject string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.session = subject
+}
+
+// RememberedLogin returns the subject of the remembered login session, if any.
+func (a *Admin) RememberedLogin() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.session, a.session != ""
+}
+
+// RememberConsent remembers that the subject granted the scopes to the client.
+func (a *Admin) RememberConsent(subject string, client *models.OAuth2Client, grantScope ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.remember[rememberKey(subject, clientID(client))] = &models.PreviousConsentSession{
+		ConsentRequest: &models.ConsentRequest{Subject: subject, Client: client},
+		GrantScope:     grantScope,
+		HandledAt:      models.NullTime(a.now()),
+		Remember:       true,
+	}
+}
+
+// Calls returns the requests made to the admin API so far.
+func (a *Admin) Calls() []Call {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	calls := make([]Call, len(a.calls))
+	copy(calls, a.calls)
+
+	return calls
+}
+
+// LastCall returns the last request of the given operation.
+func (a *Admin) LastCall(operation string) (Call, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := len(a.calls) - 1; i >= 0; i-- {
+		if a.calls[i].Operation == operation {
+			return a.calls[i], true
+		}
+	}
+
+	return Call{}, false
+}
+
+func (a *Admin) GetLoginRequest(
+	params *hydraAdmin.GetLoginRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.GetLoginRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpGetLoginRequest, params.LoginChallenge, "", nil)
+
+	l, ok := a.logins[params.LoginChallenge]
+	if !ok || a.expired(&l.handled) {
+		return nil, &hydraAdmin.GetLoginRequestNotFound{Payload: notFound()}
+	}
+
+	if l.redirectTo != "" {
+		return nil, &hydraAdmin.GetLoginRequestGone{Payload: wasHandled(l.redirectTo)}
+	}
+
+	return &hydraAdmin.GetLoginRequestOK{Payload: l.request}, nil
+}
+
+func (a *Admin) AcceptLoginRequest(
+	params *hydraAdmin.AcceptLoginRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.AcceptLoginRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpAcceptLoginRequest, params.LoginChallenge, "", params.Body)
+
+	l, ok := a.logins[params.LoginChallenge]
+	if !ok || a.expired(&l.handled) || l.redirectTo != "" {
+		return nil, &hydraAdmin.AcceptLoginRequestNotFound{Payload: notFound()}
+	}
+
+	if params.Body == nil || params.Body.Subject == nil {
+		return nil, &hydraAdmin.AcceptLoginRequestBadRequest{Payload: badRequest("subject is missing")}
+	}
+
+	// Hydra refuses to change the subject of a remembered session.
+	if *l.request.Skip && *params.Body.Subject != *l.request.Subject {
+		return nil, &hydraAdmin.AcceptLoginRequestBadRequest{Payload: badRequest("subject does not match")}
+	}
+
+	if params.Body.Remember {
+		a.session = *params.Body.Subject
+	}
+
+	l.redirectTo = a.redirect("login_verifier", params.LoginChallenge)
+
+	return &hydraAdmin.AcceptLoginRequestOK{Payload: completed(l.redirectTo)}, nil
+}
+
+func (a *Admin) RejectLoginRequest(
+	params *hydraAdmin.RejectLoginRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.RejectLoginRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpRejectLoginRequest, params.LoginChallenge, "", params.Body)
+
+	l, ok := a.logins[params.LoginChallenge]
+	if !ok || a.expired(&l.handled) || l.redirectTo != "" {
+		return nil, &hydraAdmin.RejectLoginRequestNotFound{Payload: notFound()}
+	}
+
+	l.redirectTo = a.rejected(params.Body)
+
+	return &hydraAdmin.RejectLoginRequestOK{Payload: completed(l.redirectTo)}, nil
+}
+
+func (a *Admin) GetConsentRequest(
+	params *hydraAdmin.GetConsentRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.GetConsentRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpGetConsentRequest, params.ConsentChallenge, "", nil)
+
+	c, ok := a.consents[params.ConsentChallenge]
+	if !ok || a.expired(&c.handled) {
+		return nil, &hydraAdmin.GetConsentRequestNotFound{Payload: notFound()}
+	}
+
+	if c.redirectTo != "" {
+		return nil, &hydraAdmin.GetConsentRequestGone{Payload: wasHandled(c.redirectTo)}
+	}
+
+	return &hydraAdmin.GetConsentRequestOK{Payload: c.request}, nil
+}
+
+func (a *Admin) AcceptConsentRequest(
+	params *hydraAdmin.AcceptConsentRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.AcceptConsentRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpAcceptConsentRequest, params.ConsentChallenge, "", params.Body)
+
+	c, ok := a.consents[params.ConsentChallenge]
+	if !ok || a.expired(&c.handled) || c.redirectTo != "" {
+		return nil, &hydraAdmin.AcceptConsentRequestNotFound{Payload: notFound()}
+	}
+
+	if params.Body != nil && params.Body.Remember {
+		a.remember[rememberKey(c.request.Subject, clientID(c.request.Client))] = &models.PreviousConsentSession{
+			ConsentRequest:           c.request,
+			GrantAccessTokenAudience: params.Body.GrantAccessTokenAudience,
+			GrantScope:               params.Body.GrantScope,
+			HandledAt:                models.NullTime(a.now()),
+			Remember:                 true,
+			RememberFor:              params.Body.RememberFor,
+			Session:                  params.Body.Session,
+		}
+	}
+
+	c.redirectTo = a.redirect("consent_verifier", params.ConsentChallenge)
+
+	return &hydraAdmin.AcceptConsentRequestOK{Payload: completed(c.redirectTo)}, nil
+}
+
+func (a *Admin) RejectConsentRequest(
+	params *hydraAdmin.RejectConsentRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.RejectConsentRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpRejectConsentRequest, params.ConsentChallenge, "", params.Body)
+
+	c, ok := a.consents[params.ConsentChallenge]
+	if !ok || a.expired(&c.handled) || c.redirectTo != "" {
+		return nil, &hydraAdmin.RejectConsentRequestNotFound{Payload: notFound()}
+	}
+
+	c.redirectTo = a.rejected(params.Body)
+
+	return &hydraAdmin.RejectConsentRequestOK{Payload: completed(c.redirectTo)}, nil
+}
+
+func (a *Admin) GetLogoutRequest(
+	params *hydraAdmin.GetLogoutRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.GetLogoutRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpGetLogoutRequest, params.LogoutChallenge, "", nil)
+
+	l, ok := a.logouts[params.LogoutChallenge]
+	if !ok || a.expired(&l.handled) {
+		return nil, &hydraAdmin.GetLogoutRequestNotFound{Payload: notFound()}
+	}
+
+	if l.redirectTo != "" {
+		return nil, &hydraAdmin.GetLogoutRequestGone{Payload: wasHandled(l.redirectTo)}
+	}
+
+	return &hydraAdmin.GetLogoutRequestOK{Payload: l.request}, nil
+}
+
+func (a *Admin) AcceptLogoutRequest(
+	params *hydraAdmin.AcceptLogoutRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.AcceptLogoutRequestOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpAcceptLogoutRequest, params.LogoutChallenge, "", nil)
+
+	l, ok := a.logouts[params.LogoutChallenge]
+	if !ok || a.expired(&l.handled) || l.redirectTo != "" {
+		return nil, &hydraAdmin.AcceptLogoutRequestNotFound{Payload: notFound()}
+	}
+
+	if a.session == l.request.Subject {
+		a.session = ""
+	}
+
+	l.redirectTo = a.redirect("logout_verifier", params.LogoutChallenge)
+
+	return &hydraAdmin.AcceptLogoutRequestOK{Payload: completed(l.redirectTo)}, nil
+}
+
+func (a *Admin) RejectLogoutRequest(
+	params *hydraAdmin.RejectLogoutRequestParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.RejectLogoutRequestNoContent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpRejectLogoutRequest, params.LogoutChallenge, "", params.Body)
+
+	l, ok := a.logouts[params.LogoutChallenge]
+	if !ok || a.expired(&l.handled) || l.redirectTo != "" {
+		return nil, &hydraAdmin.RejectLogoutRequestNotFound{Payload: notFound()}
+	}
+
+	l.redirectTo = a.rejected(params.Body)
+
+	return &hydraAdmin.RejectLogoutRequestNoContent{}, nil
+}
+
+func (a *Admin) ListSubjectConsentSessions(
+	params *hydraAdmin.ListSubjectConsentSessionsParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.ListSubjectConsentSessionsOK, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpListSubjectConsentSessions, "", params.Subject, nil)
+
+	var sessions []*models.PreviousConsentSession
+
+	for _, s := range a.remember {
+		if s.ConsentRequest.Subject == params.Subject {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return &hydraAdmin.ListSubjectConsentSessionsOK{Payload: sessions}, nil
+}
+
+func (a *Admin) RevokeConsentSessions(
+	params *hydraAdmin.RevokeConsentSessionsParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.RevokeConsentSessionsNoContent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpRevokeConsentSessions, "", params.Subject, params.Client)
+
+	all := params.All != nil && *params.All
+
+	if !all && params.Client == nil {
+		return nil, &hydraAdmin.RevokeConsentSessionsBadRequest{Payload: badRequest("either client or all must be set")}
+	}
+
+	for k, s := range a.remember {
+		if s.ConsentRequest.Subject != params.Subject {
+			continue
+		}
+
+		if all || clientID(s.ConsentRequest.Client) == *params.Client {
+			delete(a.remember, k)
+		}
+	}
+
+	return &hydraAdmin.RevokeConsentSessionsNoContent{}, nil
+}
+
+func (a *Admin) RevokeAuthenticationSession(
+	params *hydraAdmin.RevokeAuthenticationSessionParams,
+	_ ...hydraAdmin.ClientOption,
+) (*hydraAdmin.RevokeAuthenticationSessionNoContent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record(OpRevokeAuthenticationSession, "", params.Subject, nil)
+
+	if a.session == params.Subject {
+		a.session = ""
+	}
+
+	return &hydraAdmin.RevokeAuthenticationSessionNoContent{}, nil
+}
+
+func (a *Admin) record(operation, challenge, subject string, body interface{}) {
+	a.calls = append(a.calls, Call{
+		Operation: operation,
+		Challenge: challenge,
+		Subject:   subject,
+		Body:      body,
+	})
+}
+
+func (a *Admin) challenge(kind string) string {
+	a.counter++
+	return fmt.Sprintf("%s-challenge-%d", kind, a.counter)
+}
+
+func (a *Admin) expired(h *handled) bool {
+	return h.redirectTo == "" && a.now().After(h.expires)
+}
+
+func (a *Admin) redirect(key, challenge string) string {
+	return a.baseURL + "/oauth2/auth?" + url.Values{key: {challenge}}.Encode()
+}
+
+func (a *Admin) rejected(body *models.RejectRequest) string {
+	q := url.Values{}
+
+	if body != nil {
+		q.Set("error", body.Error)
+		q.Set("error_description", body.ErrorDescription)
+	}
+
+	return a.baseURL + "/oauth2/fallbacks/error?" + q.Encode()
+}
+
+func rememberKey(subject, client string) string {
+	return subject + "\x00" + client
+}
+
+func clientID(c *models.OAuth2Client) string {
+	if c == nil {
+		return ""
+	}
+
+	return c.ClientID
+}
+
+func covers(granted, requested []string) bool {
+	for _, r := range requested {
+		found := false
+
+		for _, g := range granted {
+			if g == r {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func completed(redirectTo string) *models.CompletedRequest {
+	return &models.CompletedRequest{RedirectTo: &redirectTo}
+}
+
+func wasHandled(redirectTo string) *models.RequestWasHandledResponse {
+	return &models.RequestWasHandledResponse{RedirectTo: &redirectTo}
+}
+
+func notFound() *models.JSONError {
+	return &models.JSONError{
+		Error:            "Not Found",
+		ErrorDescription: "Unable to locate the requested resource",
+		StatusCode:       404,
+	}
+}
+
+func badRequest(description string) *models.JSONError {
+	return &models.JSONError{
+		Error:            "invalid_request",
+		ErrorDescription: description,
+		StatusCode:       400,
+	}
+}
@@ -0,0 +1,23 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors every Provider reports authentication failures with,
+// so that they can be told apart regardless of the provider.
+var (
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrAccountLocked       = errors.New("account is locked")
+	ErrAccountDisabled     = errors.New("account is disabled")
+	ErrMFARequired         = errors.New("multi-factor authentication is required")
+	ErrUpstreamUnavailable = errors.New("upstream is unavailable")
+	ErrUpstreamInvalid     = errors.New("upstream response is invalid")
+	ErrValidation          = errors.New("credentials are invalid")
+)
+
+var (
+	ErrEmailMissing    = fmt.Errorf("%w: email is missing", ErrValidation)
+	ErrPasswordMissing = fmt.Errorf("%w: password is missing", ErrValidation)
+)
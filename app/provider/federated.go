@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+)
+
+// OIDCProvider authenticates users at an upstream OpenID Connect
+// identity provider. Upstream subjects are prefixed, so that they
+// can not collide with the subjects of the other connections.
+type OIDCProvider struct {
+	client        *oidc.Client
+	subjectPrefix string
+}
+
+const (
+	credCode         = "code"
+	credError        = "error"
+	credNonce        = "nonce"
+	credCodeVerifier = "code_verifier"
+	credRedirectURI  = "redirect_uri"
+)
+
+func NewOIDCProvider(client *oidc.Client, subjectPrefix string) *OIDCProvider {
+	return &OIDCProvider{client: client, subjectPrefix: subjectPrefix}
+}
+
+func (p *OIDCProvider) Handoff(ctx context.Context, callbackURL string) (*Handoff, error) {
+	var values [3]string
+
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = v
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	u, err := p.client.AuthCodeURL(ctx, callbackURL, state, nonce, verifier)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	return &Handoff{
+		URL:   u,
+		State: state,
+		Saved: Credentials{
+			credNonce:        nonce,
+			credCodeVerifier: verifier,
+			credRedirectURI:  callbackURL,
+		},
+	}, nil
+}
+
+func (p *OIDCProvider) Provide(ctx context.Context, creds Credentials) (*AuthenticatedIdentity, error) {
+	switch creds[credError] {
+	case "":
+	case "access_denied", "login_required", "interaction_required", "consent_required":
+		return nil, fmt.Errorf("%w: upstream error %s", ErrInvalidCredentials, creds[credError])
+	case "temporarily_unavailable", "server_error":
+		return nil, fmt.Errorf("%w: upstream error %s", ErrUpstreamUnavailable, creds[credError])
+	default:
+		return nil, fmt.Errorf("upstream error: %s", creds[credError])
+	}
+
+	if creds[credCode] == "" {
+		return nil, fmt.Errorf("%w: code is missing", ErrValidation)
+	}
+
+	token, err := p.client.Exchange(ctx, creds[credCode], creds[credRedirectURI], creds[credCodeVerifier])
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	idToken, err := p.client.Verify(ctx, token.IDToken, creds[credNonce])
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	authTime := time.Now()
+	if idToken.AuthTime != 0 {
+		authTime = time.Unix(idToken.AuthTime, 0)
+	}
+
+	return &AuthenticatedIdentity{
+		Subject:  p.subjectPrefix + idToken.Subject,
+		Traits:   claimTraits(idToken.Claims),
+		Methods:  idToken.AMR,
+		Level:    LevelOf(idToken.AMR),
+		AuthTime: authTime,
+	}, nil
+}
+
+// tokenErrors are the reasons to reject the ID token, all of which
+// tell that the callback is forged or was not meant for us.
+var tokenErrors = []error{
+	oidc.ErrIDTokenMissing,
+	oidc.ErrTokenMalformed,
+	oidc.ErrAlgorithmUnsupported,
+	oidc.ErrKeyNotFound,
+	oidc.ErrSignatureInvalid,
+	oidc.ErrIssuerMismatch,
+	oidc.ErrAudienceMismatch,
+	oidc.ErrTokenExpired,
+	oidc.ErrTokenNotYetValid,
+	oidc.ErrNonceMismatch,
+	oidc.ErrSubjectMissing,
+}
+
+func upstreamError(err error) error {
+	switch {
+	case errors.Is(err, oidc.ErrUnavailable):
+		return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+	case errors.Is(err, oidc.ErrGrantInvalid):
+		return fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	for _, tokenErr := range tokenErrors {
+		if errors.Is(err, tokenErr) {
+			return fmt.Errorf("%w: %v", ErrUpstreamInvalid, err)
+		}
+	}
+
+	return fmt.Errorf("failed to authenticate upstream: %w", err)
+}
+
+// claimTraits maps the standard claims of the upstream ID token onto traits.
+func claimTraits(claims map[string]interface{}) *Traits {
+	t := &Traits{
+		Email:               claimString(claims, "email"),
+		EmailVerified:       claimBool(claims, "email_verified"),
+		Name:                claimString(claims, "name"),
+		GivenName:           claimString(claims, "given_name"),
+		FamilyName:          claimString(claims, "family_name"),
+		Picture:             claimString(claims, "picture"),
+		Locale:              claimString(claims, "locale"),
+		PhoneNumber:         claimString(claims, "phone_number"),
+		PhoneNumberVerified: claimBool(claims, "phone_number_verified"),
+	}
+
+	if groups, ok := claims["groups"].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				t.Groups = append(t.Groups, s)
+			}
+		}
+	}
+
+	return t
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func claimBool(claims map[string]interface{}, name string) bool {
+	b, _ := claims[name].(bool)
+	return b
+}
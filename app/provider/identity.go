@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/mpraski/identity-provider/app/gateway/identities"
 )
@@ -12,12 +13,6 @@ type IdentityProvider struct {
 	client *identities.Client
 }
 
-var (
-	ErrEmailMissing    = errors.New("email is missing")
-	ErrPasswordMissing = errors.New("password is missing")
-	ErrAccountNotFound = errors.New("account not found")
-)
-
 const (
 	credEmail    = "email"
 	credPassword = "password"
@@ -27,21 +22,80 @@ func NewIdentityProvider(client *identities.Client) *IdentityProvider {
 	return &IdentityProvider{client: client}
 }
 
-func (p *IdentityProvider) Provide(ctx context.Context, creds Credentials) (Subject, error) {
-	email, ok := creds[credEmail]
-	if !ok {
-		return "", ErrEmailMissing
+func (p *IdentityProvider) Provide(ctx context.Context, creds Credentials) (*AuthenticatedIdentity, error) {
+	email := creds[credEmail]
+	if email == "" {
+		return nil, ErrEmailMissing
 	}
 
-	password, ok := creds[credPassword]
-	if !ok {
-		return "", ErrPasswordMissing
+	password := creds[credPassword]
+	if password == "" {
+		return nil, ErrPasswordMissing
 	}
 
 	identity, err := p.client.Authenticate(ctx, email, password)
+
+	switch {
+	case errors.Is(err, identities.ErrInvalidCredentials):
+		return nil, ErrInvalidCredentials
+	case errors.Is(err, identities.ErrIdentityLocked):
+		return nil, ErrAccountLocked
+	case errors.Is(err, identities.ErrIdentityDisabled):
+		return nil, ErrAccountDisabled
+	case errors.Is(err, identities.ErrMFARequired):
+		return nil, ErrMFARequired
+	case errors.Is(err, identities.ErrInvalidRequest):
+		return nil, ErrValidation
+	case errors.Is(err, identities.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	methods := identity.Methods
+	if len(methods) == 0 {
+		methods = []Method{MethodPassword}
+	}
+
+	authTime := identity.AuthenticatedAt
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+
+	return &AuthenticatedIdentity{
+		Subject:  identity.ID.String(),
+		Traits:   identityTraits(identity.Traits),
+		Methods:  methods,
+		Level:    LevelOf(methods),
+		AuthTime: authTime,
+	}, nil
+}
+
+// Traits looks up the traits of the subject in the identity manager.
+func (p *IdentityProvider) Traits(ctx context.Context, subject Subject) (*Traits, error) {
+	t, err := p.client.Traits(ctx, subject)
 	if err != nil {
-		return "", fmt.Errorf("failed to authenticate: %w", err)
+		return nil, err
+	}
+
+	return identityTraits(t), nil
+}
+
+func identityTraits(t *identities.Traits) *Traits {
+	if t == nil {
+		return nil
 	}
 
-	return identity.ID.String(), nil
+	return &Traits{
+		Email:               t.Email,
+		EmailVerified:       t.EmailVerified,
+		Name:                t.Name,
+		GivenName:           t.GivenName,
+		FamilyName:          t.FamilyName,
+		Picture:             t.Picture,
+		Locale:              t.Locale,
+		PhoneNumber:         t.PhoneNumber,
+		PhoneNumberVerified: t.PhoneNumberVerified,
+		Groups:              t.Groups,
+	}
 }
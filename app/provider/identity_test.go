@@ -0,0 +1,226 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/gateway/identities"
+	"github.com/mpraski/identity-provider/app/gateway/identitiestest"
+	"github.com/mpraski/identity-provider/app/provider"
+)
+
+const authenticatePath = "/authenticate/password"
+
+func TestIdentityProviderProvide(t *testing.T) {
+	cases := []struct {
+		name     string
+		user     identitiestest.User
+		password string
+		fault    *identitiestest.Fault
+		level    provider.Level
+		err      error
+		// errText is checked for errors none of the provider ones stand for.
+		errText  string
+		requests int
+	}{
+		{
+			name:     "authenticated",
+			password: "secret",
+			level:    provider.LevelSingleFactor,
+			requests: 1,
+		},
+		{
+			name:     "authenticated with another factor",
+			user:     identitiestest.User{Methods: []string{"pwd", "otp"}},
+			password: "secret",
+			level:    provider.LevelMultiFactor,
+			requests: 1,
+		},
+		{
+			name:     "wrong password",
+			password: "wrong",
+			err:      provider.ErrInvalidCredentials,
+			requests: 1,
+		},
+		{
+			name:     "password missing",
+			err:      provider.ErrValidation,
+			requests: 0,
+		},
+		{
+			name:     "account disabled",
+			user:     identitiestest.User{Disabled: true},
+			password: "secret",
+			err:      provider.ErrAccountDisabled,
+			requests: 1,
+		},
+		{
+			name:     "account locked",
+			user:     identitiestest.User{Locked: true},
+			password: "secret",
+			err:      provider.ErrAccountLocked,
+			requests: 1,
+		},
+		{
+			name:     "another factor required",
+			user:     identitiestest.User{MFARequired: true},
+			password: "secret",
+			err:      provider.ErrMFARequired,
+			requests: 1,
+		},
+		{
+			name:     "server error not retried",
+			password: "secret",
+			fault:    &identitiestest.Fault{Status: http.StatusServiceUnavailable, Times: 1},
+			err:      provider.ErrUpstreamUnavailable,
+			requests: 1,
+		},
+		{
+			name:     "too slow",
+			password: "secret",
+			fault:    &identitiestest.Fault{Latency: time.Second},
+			err:      provider.ErrUpstreamUnavailable,
+			requests: 1,
+		},
+		{
+			name:     "malformed response",
+			password: "secret",
+			fault:    &identitiestest.Fault{Path: authenticatePath, Malformed: true},
+			errText:  "failed to decode identity response",
+			requests: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user := c.user
+			user.Email, user.Password = "jdoe@example.com", "secret"
+			user.Traits.Name = "John Doe"
+
+			server := identitiestest.NewServer()
+			t.Cleanup(server.Close)
+
+			id := server.AddUser(user)
+
+			if c.fault != nil {
+				server.Inject(*c.fault)
+			}
+
+			p := newIdentityProvider(t, server)
+
+			a, err := p.Provide(context.Background(), provider.Credentials{
+				"email":    "jdoe@example.com",
+				"password": c.password,
+			})
+
+			switch {
+			case c.err != nil && !errors.Is(err, c.err):
+				t.Fatalf("authentication failed with %v, want %v", err, c.err)
+			case c.errText != "" && (err == nil || !strings.Contains(err.Error(), c.errText)):
+				t.Fatalf("authentication failed with %v, want %q", err, c.errText)
+			case c.err == nil && c.errText == "" && err != nil:
+				t.Fatalf("authentication failed: %v", err)
+			case err == nil && (a.Subject != id.String() || a.Level != c.level || a.Traits.Name != "John Doe"):
+				t.Fatalf("authenticated as %+v", a)
+			}
+
+			requests := server.Requests()
+			if len(requests) != c.requests {
+				t.Fatalf("%d requests made, want %d", len(requests), c.requests)
+			}
+
+			for _, r := range requests {
+				if r.Method != http.MethodPost || r.Path != authenticatePath || r.Header.Get("Authorization") != "Bearer token" {
+					t.Fatalf("request made as %s %s with %v", r.Method, r.Path, r.Header)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityProviderTraits(t *testing.T) {
+	cases := []struct {
+		name     string
+		unknown  bool
+		fault    *identitiestest.Fault
+		err      error
+		requests int
+	}{
+		{
+			name:     "looked up",
+			requests: 1,
+		},
+		{
+			name:     "looked up after server errors",
+			fault:    &identitiestest.Fault{Status: http.StatusBadGateway, Times: 2},
+			requests: 3,
+		},
+		{
+			name:     "server errors retried until given up",
+			fault:    &identitiestest.Fault{Status: http.StatusServiceUnavailable},
+			err:      identities.ErrUnavailable,
+			requests: 3,
+		},
+		{
+			name:     "subject unknown",
+			unknown:  true,
+			err:      identities.ErrIdentityNotFound,
+			requests: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := identitiestest.NewServer()
+			t.Cleanup(server.Close)
+
+			id := server.AddUser(identitiestest.User{
+				Email:  "jdoe@example.com",
+				Traits: identities.Traits{EmailVerified: true, Groups: []string{"staff"}},
+			})
+
+			if c.fault != nil {
+				server.Inject(*c.fault)
+			}
+
+			subject := id.String()
+			if c.unknown {
+				subject = "00000000-0000-0000-0000-000000000000"
+			}
+
+			traits, err := newIdentityProvider(t, server).Traits(context.Background(), subject)
+
+			switch {
+			case c.err != nil && !errors.Is(err, c.err):
+				t.Fatalf("lookup failed with %v, want %v", err, c.err)
+			case c.err == nil && err != nil:
+				t.Fatalf("lookup failed: %v", err)
+			case err == nil && (traits.Email != "jdoe@example.com" || !traits.EmailVerified || len(traits.Groups) != 1):
+				t.Fatalf("traits = %+v", traits)
+			}
+
+			if n := len(server.Requests()); n != c.requests {
+				t.Fatalf("%d requests made, want %d", n, c.requests)
+			}
+		})
+	}
+}
+
+func newIdentityProvider(t *testing.T, server *identitiestest.Server) *provider.IdentityProvider {
+	t.Helper()
+
+	client, err := identities.New(server.URL,
+		identities.WithBearerToken("token"),
+		identities.WithTimeout(100*time.Millisecond),
+		identities.WithRetry(3, time.Millisecond, 5*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider.NewIdentityProvider(client)
+}
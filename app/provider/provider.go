@@ -2,14 +2,149 @@ package provider
 
 import (
 	"context"
+	"strconv"
+	"time"
 )
 
 type (
 	Provider interface {
-		Provide(context.Context, Credentials) (Subject, error)
+		Provide(context.Context, Credentials) (*AuthenticatedIdentity, error)
+	}
+
+	// Federated is a provider which authenticates users elsewhere.
+	// The user is sent to the URL of the handoff, and once they are
+	// back at the callback, its parameters are given to Provide along
+	// with the saved credentials of the handoff.
+	Federated interface {
+		Provider
+		Handoff(ctx context.Context, callbackURL string) (*Handoff, error)
+	}
+
+	// TraitsSource is a provider which looks up the traits of the subjects
+	// it authenticated, for when they were not kept from the authentication.
+	TraitsSource interface {
+		Provider
+		Traits(ctx context.Context, subject Subject) (*Traits, error)
+	}
+
+	// Handoff is where to send the user to authenticate, along with what
+	// to keep until they are back. The state is echoed in the callback.
+	// CrossSitePost is set if the user comes back with a form posted
+	// from the site of the provider, rather than by a redirect.
+	Handoff struct {
+		URL           string
+		State         string
+		Saved         Credentials
+		CrossSitePost bool
 	}
 
 	Credentials = map[string]string
 
 	Subject = string
+
+	// Traits describe the subject, as far as the provider knows.
+	// They are carried over to the consent request as JSON.
+	Traits struct {
+		Email               string   `json:"email"`
+		EmailVerified       bool     `json:"email_verified"`
+		Name                string   `json:"name"`
+		GivenName           string   `json:"given_name"`
+		FamilyName          string   `json:"family_name"`
+		Picture             string   `json:"picture"`
+		Locale              string   `json:"locale"`
+		PhoneNumber         string   `json:"phone_number"`
+		PhoneNumberVerified bool     `json:"phone_number_verified"`
+		Groups              []string `json:"groups,omitempty"`
+	}
+
+	// AuthenticatedIdentity describes who has authenticated, how and when,
+	// along with the traits of the subject if the provider knows them.
+	AuthenticatedIdentity struct {
+		Subject  Subject
+		Traits   *Traits
+		Methods  []Method
+		Level    Level
+		AuthTime time.Time
+	}
+
+	// Method is an authentication method
+	// reference as defined by RFC 8176.
+	Method = string
+
+	// Level is the assurance level of an authentication,
+	// reported to the clients as the acr claim.
+	Level int
+)
+
+const (
+	MethodPassword    Method = "pwd"
+	MethodOTP         Method = "otp"
+	MethodHardwareKey Method = "hwk"
+	MethodMultiFactor Method = "mfa"
+)
+
+const (
+	LevelNone Level = iota
+	LevelSingleFactor
+	LevelMultiFactor
 )
+
+// factor is a class of authentication factor, of which
+// multi-factor authentication takes more than one.
+type factor int
+
+const (
+	factorKnowledge factor = iota + 1
+	factorPossession
+	factorInherence
+)
+
+// factors maps the RFC 8176 methods onto the factor they stand for.
+// Methods not telling the factor, like "user" or "rba", are left out.
+var factors = map[Method]factor{
+	"pwd": factorKnowledge, "pin": factorKnowledge, "kba": factorKnowledge,
+	"otp": factorPossession, "hwk": factorPossession, "swk": factorPossession,
+	"sc": factorPossession, "sms": factorPossession, "tel": factorPossession,
+	"fpt": factorInherence, "face": factorInherence, "iris": factorInherence,
+	"retina": factorInherence, "vbm": factorInherence,
+}
+
+// LevelOf returns the assurance level achieved with the methods.
+// Multi-factor takes methods of two different known factors, so
+// that a password and a PIN, or two unknown methods, do not count.
+func LevelOf(methods []Method) Level {
+	if len(methods) == 0 {
+		return LevelNone
+	}
+
+	seen := make(map[factor]bool, len(methods))
+
+	for _, m := range methods {
+		if m == MethodMultiFactor {
+			return LevelMultiFactor
+		}
+
+		if f, ok := factors[m]; ok {
+			seen[f] = true
+		}
+	}
+
+	if len(seen) > 1 {
+		return LevelMultiFactor
+	}
+
+	return LevelSingleFactor
+}
+
+func (l Level) ACR() string {
+	return strconv.Itoa(int(l))
+}
+
+func ParseLevel(acr string) (Level, bool) {
+	n, err := strconv.Atoi(acr)
+	if err != nil || n < int(LevelNone) || n > int(LevelMultiFactor) {
+		return LevelNone, false
+	}
+
+	return Level(n), true
+}
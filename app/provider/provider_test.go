@@ -0,0 +1,36 @@
+package provider_test
+
+import (
+	"testing"
+
+	"github.com/mpraski/identity-provider/app/provider"
+)
+
+func TestLevelOf(t *testing.T) {
+	cases := []struct {
+		name    string
+		methods []provider.Method
+		level   provider.Level
+	}{
+		{name: "no methods", level: provider.LevelNone},
+		{name: "password", methods: []string{"pwd"}, level: provider.LevelSingleFactor},
+		{name: "unknown method", methods: []string{"user"}, level: provider.LevelSingleFactor},
+		{name: "password and one-time password", methods: []string{"pwd", "otp"}, level: provider.LevelMultiFactor},
+		{name: "PIN and fingerprint", methods: []string{"pin", "fpt"}, level: provider.LevelMultiFactor},
+		{name: "hardware key and face", methods: []string{"hwk", "face"}, level: provider.LevelMultiFactor},
+		{name: "multi-factor", methods: []string{"mfa"}, level: provider.LevelMultiFactor},
+		{name: "password and PIN", methods: []string{"pwd", "pin"}, level: provider.LevelSingleFactor},
+		{name: "one-time password and SMS", methods: []string{"otp", "sms"}, level: provider.LevelSingleFactor},
+		{name: "same method twice", methods: []string{"pwd", "pwd"}, level: provider.LevelSingleFactor},
+		{name: "unknown methods", methods: []string{"user", "rba"}, level: provider.LevelSingleFactor},
+		{name: "password and unknown method", methods: []string{"pwd", "geo"}, level: provider.LevelSingleFactor},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := provider.LevelOf(c.methods); got != c.level {
+				t.Fatalf("level = %d, want %d", got, c.level)
+			}
+		})
+	}
+}
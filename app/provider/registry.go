@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Connection is a named way of authenticating users, backed by
+	// a provider. Users with emails in any of the domains are sent
+	// to it during home realm discovery.
+	Connection struct {
+		Name     string
+		Title    string
+		Domains  []string
+		Provider Provider
+	}
+
+	// Registry holds the connections configured at startup,
+	// in the order they are offered to the users.
+	Registry struct {
+		connections []*Connection
+		byName      map[string]*Connection
+	}
+)
+
+var (
+	ErrConnectionNameMissing     = errors.New("connection name is missing")
+	ErrConnectionProviderMissing = errors.New("connection provider is missing")
+	ErrConnectionDuplicate       = errors.New("connection is registered more than once")
+)
+
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*Connection)}
+}
+
+// Register adds the connection, titled after its name if it has no title.
+func (r *Registry) Register(c Connection) error {
+	switch {
+	case c.Name == "":
+		return ErrConnectionNameMissing
+	case c.Provider == nil:
+		return fmt.Errorf("%s: %w", c.Name, ErrConnectionProviderMissing)
+	case r.byName[c.Name] != nil:
+		return fmt.Errorf("%s: %w", c.Name, ErrConnectionDuplicate)
+	}
+
+	if c.Title == "" {
+		c.Title = c.Name
+	}
+
+	r.connections = append(r.connections, &c)
+	r.byName[c.Name] = &c
+
+	return nil
+}
+
+// Routes tells whether users of the email domain belong to the
+// connection. Domains may be configured with a leading @.
+func (c *Connection) Routes(domain string) bool {
+	for _, d := range c.Domains {
+		if strings.EqualFold(strings.TrimPrefix(d, "@"), domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Registry) Lookup(name string) (*Connection, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// Connections returns all the connections in the order they were registered.
+func (r *Registry) Connections() []*Connection {
+	connections := make([]*Connection, len(r.connections))
+	copy(connections, r.connections)
+
+	return connections
+}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+	"github.com/mpraski/identity-provider/app/gateway/saml"
+)
+
+type (
+	// SAMLProvider authenticates users at an upstream SAML 2.0 identity
+	// provider. Like the ones of OIDC connections, upstream subjects
+	// are prefixed to keep them apart from those of other connections.
+	SAMLProvider struct {
+		sp            *saml.ServiceProvider
+		attributes    AttributeMap
+		subjectPrefix string
+	}
+
+	// AttributeMap names the assertion attributes the traits are read
+	// from. The subject is the name id, unless an attribute is named.
+	AttributeMap struct {
+		Subject    string
+		Email      string
+		Name       string
+		GivenName  string
+		FamilyName string
+		Groups     string
+	}
+)
+
+const (
+	credSAMLResponse = "SAMLResponse"
+	credRequestID    = "request_id"
+)
+
+// DefaultAttributes are the attribute names of the SAML V2.0 X.500/LDAP
+// attribute profile, which most identity providers can be configured with.
+var DefaultAttributes = AttributeMap{
+	Email:      "urn:oid:0.9.2342.19200300.100.1.3",
+	Name:       "urn:oid:2.16.840.1.113730.3.1.241",
+	GivenName:  "urn:oid:2.5.4.42",
+	FamilyName: "urn:oid:2.5.4.4",
+	Groups:     "urn:oid:1.3.6.1.4.1.5923.1.5.1.1",
+}
+
+// authnContextMethods maps authentication context classes onto methods.
+var authnContextMethods = map[string][]Method{
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Password":                   {MethodPassword},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport": {MethodPassword},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:TimeSyncToken":              {MethodOTP},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:X509":                       {MethodHardwareKey},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Smartcard":                  {MethodHardwareKey},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:SmartcardPKI":               {MethodHardwareKey},
+	"https://refeds.org/profile/mfa":                                    {MethodMultiFactor},
+	"http://schemas.microsoft.com/claims/multipleauthn":                 {MethodMultiFactor},
+}
+
+func NewSAMLProvider(sp *saml.ServiceProvider, attributes AttributeMap, subjectPrefix string) *SAMLProvider {
+	return &SAMLProvider{sp: sp, attributes: attributes, subjectPrefix: subjectPrefix}
+}
+
+// Metadata describes this service to the identity provider.
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	return p.sp.Metadata()
+}
+
+// Handoff sends the user to the identity provider, which posts the response
+// to the ACS of the service provider rather than to the callback URL.
+func (p *SAMLProvider) Handoff(_ context.Context, _ string) (*Handoff, error) {
+	// The relay state is limited to 80 bytes, which a random string fits in.
+	state, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	u, requestID, err := p.sp.AuthnRequestURL(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handoff{
+		URL:           u,
+		State:         state,
+		Saved:         Credentials{credRequestID: requestID},
+		CrossSitePost: true,
+	}, nil
+}
+
+func (p *SAMLProvider) Provide(_ context.Context, creds Credentials) (*AuthenticatedIdentity, error) {
+	if creds[credSAMLResponse] == "" {
+		return nil, fmt.Errorf("%w: SAML response is missing", ErrValidation)
+	}
+
+	// Every reason to reject the response is about the response itself,
+	// which is either forged or not meant for us, unless the identity
+	// provider tells that it could not authenticate the user.
+	a, err := p.sp.ParseResponse(creds[credSAMLResponse], creds[credRequestID])
+	if err != nil {
+		if errors.Is(err, saml.ErrAuthnFailed) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamInvalid, err)
+	}
+
+	subject := a.NameID
+	if p.attributes.Subject != "" {
+		if subject = a.Attribute(p.attributes.Subject); subject == "" {
+			return nil, fmt.Errorf("%w: subject attribute %s is missing", ErrUpstreamInvalid, p.attributes.Subject)
+		}
+	}
+
+	authTime := a.AuthnInstant
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+
+	methods := authnContextMethods[a.AuthnContext]
+
+	return &AuthenticatedIdentity{
+		Subject:  p.subjectPrefix + subject,
+		Traits:   p.attributeTraits(a),
+		Methods:  methods,
+		Level:    LevelOf(methods),
+		AuthTime: authTime,
+	}, nil
+}
+
+// attributeTraits maps the attributes of the assertion onto traits.
+func (p *SAMLProvider) attributeTraits(a *saml.Assertion) *Traits {
+	return &Traits{
+		Email:      a.Attribute(p.attributes.Email),
+		Name:       a.Attribute(p.attributes.Name),
+		GivenName:  a.Attribute(p.attributes.GivenName),
+		FamilyName: a.Attribute(p.attributes.FamilyName),
+		Groups:     a.Attributes[p.attributes.Groups],
+	}
+}
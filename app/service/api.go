@@ -0,0 +1,361 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/csrf"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/template"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	apiClient struct {
+		ID        string `json:"id"`
+		Name      string `json:"name,omitempty"`
+		LogoURI   string `json:"logo_uri,omitempty"`
+		ClientURI string `json:"client_uri,omitempty"`
+		PolicyURI string `json:"policy_uri,omitempty"`
+		TosURI    string `json:"tos_uri,omitempty"`
+	}
+
+	apiLogin struct {
+		Challenge      string            `json:"login_challenge"`
+		Client         *apiClient        `json:"client,omitempty"`
+		RequestedScope []string          `json:"requested_scope"`
+		LoginHint      string            `json:"login_hint,omitempty"`
+		Connections    []loginConnection `json:"connections"`
+		// IdentifierFirst tells to ask for the email first
+		// and identify the connection to use with it.
+		IdentifierFirst bool   `json:"identifier_first"`
+		Locale          string `json:"locale"`
+		CSRFToken       string `json:"csrf_token"`
+	}
+
+	apiLoginRequest struct {
+		Challenge  string `json:"login_challenge"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		Connection string `json:"connection,omitempty"`
+		Remember   bool   `json:"remember"`
+	}
+
+	apiIdentifyRequest struct {
+		Challenge string `json:"login_challenge"`
+		Email     string `json:"email"`
+	}
+
+	apiFederateRequest struct {
+		Challenge  string `json:"login_challenge"`
+		Connection string `json:"connection"`
+	}
+
+	apiIdentity struct {
+		Connection loginConnection `json:"connection"`
+	}
+
+	apiConsent struct {
+		Challenge          string              `json:"consent_challenge"`
+		Client             *apiClient          `json:"client,omitempty"`
+		Subject            string              `json:"subject"`
+		ScopeGroups        []consentScopeGroup `json:"scope_groups"`
+		RequestedAudiences []consentAudience   `json:"requested_audiences"`
+		CSRFToken          string              `json:"csrf_token"`
+	}
+
+	apiConsentRequest struct {
+		Challenge     string   `json:"consent_challenge"`
+		Action        string   `json:"action"`
+		GrantScope    []string `json:"grant_scope"`
+		GrantAudience []string `json:"grant_audience"`
+		Remember      bool     `json:"remember"`
+	}
+
+	apiRedirect struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	apiError struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+)
+
+const (
+	apiErrInvalidRequest  = "invalid_request"
+	apiErrRequestNotFound = "request_not_found"
+	apiErrServerError     = "server_error"
+
+	// maxRequestSize limits the request bodies, which are all small.
+	maxRequestSize = 64 << 10
+)
+
+func (s *Service) apiBeginLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	challenge := strings.TrimSpace(r.URL.Query().Get(loginChallengeKey))
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a login challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	if redirectTo, done, err := s.skipLogin(r, req); done {
+		apiComplete(w, redirectTo, err)
+		return
+	}
+
+	var (
+		o           = loginParams(req)
+		locale, _   = template.Localize(o.uiLocales)
+		connections = s.allowedConnections(req.Client)
+	)
+
+	writeJSON(w, http.StatusOK, &apiLogin{
+		Challenge:       challenge,
+		Client:          newAPIClient(req.Client),
+		RequestedScope:  req.RequestedScope,
+		LoginHint:       o.loginHint,
+		Connections:     loginConnections(connections),
+		IdentifierFirst: identifierFirst(connections),
+		Locale:          locale,
+		CSRFToken:       csrf.Token(r),
+	})
+}
+
+func (s *Service) apiIdentifyLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body apiIdentifyRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Failed to decode the request body")
+		return
+	}
+
+	challenge := strings.TrimSpace(body.Challenge)
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a login challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	c, ok := discoverConnection(s.allowedConnections(req.Client), strings.TrimSpace(body.Email))
+	if !ok {
+		_, messages := template.Localize(loginParams(req).uiLocales)
+		writeError(w, http.StatusBadRequest, "unknown_domain", messages.UnknownDomain)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiIdentity{
+		Connection: loginConnections([]*provider.Connection{c})[0],
+	})
+}
+
+func (s *Service) apiCompleteLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body apiLoginRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Failed to decode the request body")
+		return
+	}
+
+	challenge := strings.TrimSpace(body.Challenge)
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a login challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	conn, i, err := s.provide(r.Context(), req, strings.TrimSpace(body.Connection), provider.Credentials{
+		"email":    strings.TrimSpace(body.Email),
+		"password": body.Password,
+	})
+	if err == nil {
+		err = requireLevel(req, i)
+	}
+
+	if err != nil {
+		_, messages := template.Localize(loginParams(req).uiLocales)
+		f := classifyLoginError(err, messages)
+
+		if f.reject != nil {
+			redirectTo, err := s.rejectLoginRequest(r.Context(), challenge, *f.reject)
+			apiComplete(w, redirectTo, err)
+
+			return
+		}
+
+		writeError(w, f.status, f.code, f.message)
+
+		return
+	}
+
+	redirectTo, err := s.authenticated(w, r, req, conn.Name, i, body.Remember)
+	apiComplete(w, redirectTo, err)
+}
+
+// apiFederateLogin hands the login off to the federated connection,
+// telling where to send the user. They come back to the HTML callback.
+func (s *Service) apiFederateLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body apiFederateRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Failed to decode the request body")
+		return
+	}
+
+	challenge := strings.TrimSpace(body.Challenge)
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a login challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	redirectTo, err := s.handoff(w, r, req, strings.TrimSpace(body.Connection))
+	if err != nil {
+		_, messages := template.Localize(loginParams(req).uiLocales)
+		f := classifyLoginError(err, messages)
+		writeError(w, f.status, f.code, f.message)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiRedirect{RedirectTo: redirectTo})
+}
+
+func (s *Service) apiBeginConsent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	challenge := strings.TrimSpace(r.URL.Query().Get(consentChallengeKey))
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a consent challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getConsentRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	if redirectTo, done, err := s.skipConsent(r.Context(), req); done {
+		apiComplete(w, redirectTo, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiConsent{
+		Challenge:          challenge,
+		Client:             newAPIClient(req.Client),
+		Subject:            req.Subject,
+		ScopeGroups:        s.consentScopeGroups(req.RequestedScope),
+		RequestedAudiences: s.consentAudiences(req.RequestedAccessTokenAudience),
+		CSRFToken:          csrf.Token(r),
+	})
+}
+
+func (s *Service) apiCompleteConsent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body apiConsentRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Failed to decode the request body")
+		return
+	}
+
+	challenge := strings.TrimSpace(body.Challenge)
+	if challenge == "" {
+		writeError(w, http.StatusBadRequest, apiErrInvalidRequest, "Expected a consent challenge to be set but received none")
+		return
+	}
+
+	req, err := s.getConsentRequest(r.Context(), challenge)
+	if err != nil {
+		apiChallengeFailed(w, err)
+		return
+	}
+
+	redirectTo, err := s.decideConsent(r.Context(), req, &consentDecision{
+		deny:     body.Action == actionDeny,
+		remember: body.Remember,
+		scope:    body.GrantScope,
+		audience: body.GrantAudience,
+	})
+	apiComplete(w, redirectTo, err)
+}
+
+func newAPIClient(c *models.OAuth2Client) *apiClient {
+	if c == nil {
+		return nil
+	}
+
+	return &apiClient{
+		ID:        c.ClientID,
+		Name:      c.ClientName,
+		LogoURI:   c.LogoURI,
+		ClientURI: c.ClientURI,
+		PolicyURI: c.PolicyURI,
+		TosURI:    c.TosURI,
+	}
+}
+
+// apiChallengeFailed mirrors challengeFailed, telling the front
+// end where to go if the request has already been handled.
+func apiChallengeFailed(w http.ResponseWriter, err error) {
+	if redirectTo := handledRedirect(err); redirectTo != nil {
+		writeJSON(w, http.StatusOK, &apiRedirect{RedirectTo: *redirectTo})
+		return
+	}
+
+	if challengeNotFound(err) {
+		writeError(w, http.StatusNotFound, apiErrRequestNotFound, "The request has expired or does not exist")
+		return
+	}
+
+	log.WithError(err).Error("failed to get request info")
+	writeError(w, http.StatusBadGateway, apiErrServerError, "Failed to get request info")
+}
+
+func apiComplete(w http.ResponseWriter, redirectTo string, err error) {
+	if err != nil {
+		log.WithError(err).Error("failed to complete request")
+		writeError(w, http.StatusInternalServerError, apiErrServerError, "Failed to complete request")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiRedirect{RedirectTo: redirectTo})
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, &apiError{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/csrf"
+	"github.com/mpraski/identity-provider/app/session"
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+	log "github.com/sirupsen/logrus"
+)
+
+type connectedApp struct {
+	ClientID   string
+	ClientName string
+	LogoURI    string
+	Scopes     []string
+	GrantedAt  string
+}
+
+const (
+	clientIDKey     = "client_id"
+	grantedAtLayout = "2 January 2006"
+)
+
+func (s *Service) listApps(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	subject := session.Subject(r)
+	if subject == "" {
+		s.unauthenticated(w, r)
+		return
+	}
+
+	params := hydraAdmin.NewListSubjectConsentSessionsParams()
+	params.WithContext(r.Context())
+	params.SetSubject(subject)
+
+	resp, err := s.hydra.ListSubjectConsentSessions(params)
+	if err != nil {
+		log.WithError(err).Error("failed to list consent sessions")
+
+		_ = s.renderer.Render(w, http.StatusInternalServerError, "error", map[string]interface{}{
+			"ErrorMessage": "Failed to list connected apps",
+		})
+
+		return
+	}
+
+	var (
+		apps = make([]connectedApp, 0, len(resp.GetPayload()))
+		seen = make(map[string]bool, len(resp.GetPayload()))
+	)
+
+	for _, c := range resp.GetPayload() {
+		if c.ConsentRequest == nil || c.ConsentRequest.Client == nil {
+			continue
+		}
+
+		client := c.ConsentRequest.Client
+
+		// Hydra returns a session per remembered consent,
+		// but they are revoked for the client as a whole.
+		if seen[client.ClientID] {
+			continue
+		}
+
+		seen[client.ClientID] = true
+
+		app := connectedApp{
+			ClientID:   client.ClientID,
+			ClientName: client.ClientName,
+			LogoURI:    client.LogoURI,
+			Scopes:     c.GrantScope,
+		}
+
+		if app.ClientName == "" {
+			app.ClientName = client.ClientID
+		}
+
+		if t := time.Time(c.HandledAt); !t.IsZero() {
+			app.GrantedAt = t.Format(grantedAtLayout)
+		}
+
+		apps = append(apps, app)
+	}
+
+	_ = s.renderer.Render(w, http.StatusOK, "apps", csrf.WithToken(r, map[string]interface{}{
+		"Apps": apps,
+	}))
+}
+
+func (s *Service) revokeApp(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	subject := session.Subject(r)
+	if subject == "" {
+		s.unauthenticated(w, r)
+		return
+	}
+
+	client := strings.TrimSpace(r.PostFormValue(clientIDKey))
+	if client == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	params := hydraAdmin.NewRevokeConsentSessionsParams()
+	params.WithContext(r.Context())
+	params.SetSubject(subject)
+	params.SetClient(&client)
+
+	if _, err := s.hydra.RevokeConsentSessions(params); err != nil {
+		log.WithError(err).Error("failed to revoke consent sessions")
+
+		_ = s.renderer.Render(w, http.StatusInternalServerError, "error", map[string]interface{}{
+			"ErrorMessage": "Failed to revoke access of the app",
+		})
+
+		return
+	}
+
+	http.Redirect(w, r, "/account/apps", http.StatusSeeOther)
+}
+
+func (s *Service) unauthenticated(w http.ResponseWriter, r *http.Request) {
+	if s.config.AccountLoginURL != "" {
+		http.Redirect(w, r, s.config.AccountLoginURL, http.StatusFound)
+		return
+	}
+
+	_ = s.renderer.Render(w, http.StatusUnauthorized, "error", map[string]interface{}{
+		"ErrorMessage": "Please sign in to continue",
+	})
+}
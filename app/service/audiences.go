@@ -0,0 +1,25 @@
+package service
+
+type consentAudience struct {
+	Audience string `json:"audience"`
+	Name     string `json:"name"`
+}
+
+func (s *Service) consentAudiences(requested []string) []consentAudience {
+	audiences := make([]consentAudience, 0, len(requested))
+
+	for _, audience := range requested {
+		audiences = append(audiences, consentAudience{
+			Audience: audience,
+			Name:     s.config.Catalog.Audiences.Name(audience),
+		})
+	}
+
+	return audiences
+}
+
+// grantAudiences intersects the submitted audiences with the requested
+// ones. Submitted audiences which were never requested are returned separately.
+func (s *Service) grantAudiences(requested, submitted []string) (granted, unrequested []string) {
+	return intersect(requested, submitted, nil)
+}
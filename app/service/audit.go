@@ -0,0 +1,11 @@
+package service
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// audit records a security relevant event. Those are logged
+// as warnings so that they survive the default log level.
+func audit(event string, fields log.Fields) {
+	log.WithFields(fields).WithField("audit_event", event).Warn("audit event")
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mpraski/identity-provider/app/claims"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// scopeClaims lists the standard OIDC claims
+// each of the scopes grants access to.
+var scopeClaims = map[string][]string{
+	"email":   {"email", "email_verified"},
+	"profile": {"name", "given_name", "family_name", "picture", "locale"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+func (s *Service) session(
+	ctx context.Context,
+	req *models.ConsentRequest,
+	grantScope []string,
+) (*models.ConsentRequestSession, error) {
+	traits, err := s.traits(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	available := traitClaims(traits)
+
+	idToken, accessToken := make(claims.Claims), make(claims.Claims)
+
+	for _, scope := range grantScope {
+		for _, claim := range scopeClaims[scope] {
+			if v, ok := available[claim]; ok {
+				idToken[claim] = v
+				accessToken[claim] = v
+			}
+		}
+	}
+
+	// The rules are applied last, so that they may override the standard claims.
+	ruleIDToken, ruleAccessToken := s.config.ClaimRules.Apply(available, clientID(req.Client), grantScope)
+
+	for claim, v := range ruleIDToken {
+		idToken[claim] = v
+	}
+
+	for claim, v := range ruleAccessToken {
+		accessToken[claim] = v
+	}
+
+	return &models.ConsentRequestSession{
+		IDToken:     idToken,
+		AccessToken: accessToken,
+	}, nil
+}
+
+// traits returns the traits carried over from the login request. Without
+// them, they are looked up with the connection the subject authenticated
+// with, if it can do so, as subjects of different connections may clash.
+func (s *Service) traits(ctx context.Context, req *models.ConsentRequest) (*provider.Traits, error) {
+	if traits, ok := contextTraits(req.Context); ok {
+		return traits, nil
+	}
+
+	name := contextConnection(req.Context)
+
+	conn, ok := s.connections.Lookup(name)
+	if !ok {
+		log.WithField("subject", req.Subject).Warn("connection of the subject is unknown, no traits are available")
+		return nil, nil
+	}
+
+	source, ok := conn.Provider.(provider.TraitsSource)
+	if !ok {
+		return nil, nil
+	}
+
+	traits, err := source.Traits(ctx, req.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traits from connection %s: %w", name, err)
+	}
+
+	return traits, nil
+}
+
+func traitClaims(t *provider.Traits) claims.Claims {
+	if t == nil {
+		return claims.Claims{}
+	}
+
+	c := claims.Claims{
+		"email_verified":        t.EmailVerified,
+		"phone_number_verified": t.PhoneNumberVerified,
+	}
+
+	for claim, v := range map[string]string{
+		"email":        t.Email,
+		"name":         t.Name,
+		"given_name":   t.GivenName,
+		"family_name":  t.FamilyName,
+		"picture":      t.Picture,
+		"locale":       t.Locale,
+		"phone_number": t.PhoneNumber,
+	} {
+		if v != "" {
+			c[claim] = v
+		}
+	}
+
+	if len(t.Groups) != 0 {
+		c["groups"] = t.Groups
+	}
+
+	return c
+}
+
+func clientID(c *models.OAuth2Client) string {
+	if c == nil {
+		return ""
+	}
+
+	return c.ClientID
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// loginConnection is a connection as offered on the login page.
+type loginConnection struct {
+	Name      string `json:"name"`
+	Title     string `json:"title"`
+	Federated bool   `json:"federated"`
+}
+
+const connectionKey = "connection"
+
+var errUnknownConnection = errors.New("connection is unknown or not allowed for the client")
+
+// allowedConnections returns the connections the client may use: all
+// of them, unless its connections metadata lists the allowed ones.
+// Metadata which is not a list of names allows none, as it is meant
+// to restrict the client, but is logged to tell why.
+func (s *Service) allowedConnections(c *models.OAuth2Client) []*provider.Connection {
+	v, ok := clientMetadata(c, metadataConnections)
+	if !ok {
+		return s.connections.Connections()
+	}
+
+	names, ok := v.([]interface{})
+	if !ok {
+		log.WithField("client_id", clientID(c)).Warnf("client metadata %s is not a list, allowing no connection", metadataConnections)
+		return nil
+	}
+
+	allowed := make([]*provider.Connection, 0, len(names))
+
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok {
+			log.WithField("client_id", clientID(c)).Warnf("client metadata %s lists %v, which is not a name", metadataConnections, n)
+			continue
+		}
+
+		if conn, ok := s.connections.Lookup(name); ok {
+			allowed = append(allowed, conn)
+		}
+	}
+
+	return allowed
+}
+
+// selectConnection returns the allowed connection of the given name,
+// or the only allowed one if no name is given.
+func (s *Service) selectConnection(c *models.OAuth2Client, name string) (*provider.Connection, bool) {
+	allowed := s.allowedConnections(c)
+
+	if name == "" && len(allowed) == 1 {
+		return allowed[0], true
+	}
+
+	for _, conn := range allowed {
+		if conn.Name == name {
+			return conn, true
+		}
+	}
+
+	return nil, false
+}
+
+// provide authenticates the user with the connection they chose,
+// returning it along with the authenticated identity.
+func (s *Service) provide(
+	ctx context.Context,
+	req *models.LoginRequest,
+	name string,
+	creds provider.Credentials,
+) (*provider.Connection, *provider.AuthenticatedIdentity, error) {
+	conn, ok := s.selectConnection(req.Client, name)
+	if !ok {
+		return nil, nil, errUnknownConnection
+	}
+
+	// Federated connections only accept credentials at the callback.
+	if _, ok := conn.Provider.(provider.Federated); ok {
+		return nil, nil, errUnknownConnection
+	}
+
+	i, err := conn.Provider.Provide(ctx, creds)
+
+	return conn, i, err
+}
+
+// identifierFirst tells whether users have to enter their email first,
+// so that the connection can be discovered, which is the case once
+// any of the connections routes users by their email domain.
+func identifierFirst(connections []*provider.Connection) bool {
+	for _, c := range connections {
+		if len(c.Domains) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discoverConnection finds the connection for the domain of the email:
+// the one routing the domain, or else the first one routing no domain.
+func discoverConnection(connections []*provider.Connection, email string) (*provider.Connection, bool) {
+	i := strings.LastIndex(email, "@")
+	if i < 0 || i == len(email)-1 {
+		return nil, false
+	}
+
+	var (
+		domain   = email[i+1:]
+		fallback *provider.Connection
+	)
+
+	for _, c := range connections {
+		if c.Routes(domain) {
+			return c, true
+		}
+
+		if len(c.Domains) == 0 && fallback == nil {
+			fallback = c
+		}
+	}
+
+	return fallback, fallback != nil
+}
+
+func loginConnections(connections []*provider.Connection) []loginConnection {
+	l := make([]loginConnection, len(connections))
+	for i, c := range connections {
+		_, federated := c.Provider.(provider.Federated)
+		l[i] = loginConnection{Name: c.Name, Title: c.Title, Federated: federated}
+	}
+
+	return l
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// rejection describes an unrecoverable failure which
+// is reported back to the relying party through Hydra.
+type rejection struct {
+	err         string
+	description string
+	status      int
+}
+
+var (
+	rejectAccessDenied = rejection{
+		err:         "access_denied",
+		description: "The resource owner denied the request",
+		status:      http.StatusForbidden,
+	}
+	rejectAccountDisabled = rejection{
+		err:         "access_denied",
+		description: "The account is disabled",
+		status:      http.StatusForbidden,
+	}
+	rejectInvalidScope = rejection{
+		err:         "invalid_scope",
+		description: "The granted scope was never requested",
+		status:      http.StatusBadRequest,
+	}
+	rejectInvalidAudience = rejection{
+		err:         "invalid_request",
+		description: "The granted audience was never requested",
+		status:      http.StatusBadRequest,
+	}
+	rejectLoginRequired = rejection{
+		err:         "login_required",
+		description: "The user is not signed in and the client asked for no prompt",
+		status:      http.StatusUnauthorized,
+	}
+	rejectNoConnection = rejection{
+		err:         "access_denied",
+		description: "No authentication connection is available to the client",
+		status:      http.StatusForbidden,
+	}
+	rejectServerError = rejection{
+		err:         "server_error",
+		description: "The authorization server encountered an unexpected condition",
+		status:      http.StatusInternalServerError,
+	}
+)
+
+func (j rejection) request() *models.RejectRequest {
+	return &models.RejectRequest{
+		Error:            j.err,
+		ErrorDescription: j.description,
+		StatusCode:       int64(j.status),
+	}
+}
+
+func (s *Service) rejectLogin(w http.ResponseWriter, r *http.Request, challenge string, j rejection) {
+	redirectTo, err := s.rejectLoginRequest(r.Context(), challenge, j)
+	s.complete(w, r, redirectTo, err, "Failed to reject login request")
+}
+
+// complete sends the user wherever Hydra said they should go next,
+// or shows an error page if not even the rejection went through.
+func (s *Service) complete(w http.ResponseWriter, r *http.Request, redirectTo string, err error, message string) {
+	if err != nil {
+		log.WithError(err).Error(message)
+
+		_ = s.renderer.Render(w, http.StatusInternalServerError, "error", map[string]interface{}{
+			"ErrorMessage": message,
+		})
+
+		return
+	}
+
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+func (s *Service) rejectLoginRequest(ctx context.Context, challenge string, j rejection) (string, error) {
+	params := hydraAdmin.NewRejectLoginRequestParams()
+	params.WithContext(ctx)
+	params.SetLoginChallenge(challenge)
+	params.SetBody(j.request())
+
+	reqReject, err := s.hydra.RejectLoginRequest(params)
+	if err != nil {
+		return "", err
+	}
+
+	return *reqReject.GetPayload().RedirectTo, nil
+}
+
+func (s *Service) rejectConsentRequest(ctx context.Context, challenge string, j rejection) (string, error) {
+	params := hydraAdmin.NewRejectConsentRequestParams()
+	params.WithContext(ctx)
+	params.SetConsentChallenge(challenge)
+	params.SetBody(j.request())
+
+	reqReject, err := s.hydra.RejectConsentRequest(params)
+	if err != nil {
+		return "", err
+	}
+
+	return *reqReject.GetPayload().RedirectTo, nil
+}
+
+// challengeFailed handles a failure to fetch a login, consent or logout
+// request. Such a challenge can not be rejected anymore, so the user
+// is either sent to where Hydra says the handled request went,
+// or shown an error page.
+func (s *Service) challengeFailed(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if redirectTo := handledRedirect(err); redirectTo != nil {
+		http.Redirect(w, r, *redirectTo, http.StatusFound)
+		return
+	}
+
+	status := http.StatusInternalServerError
+
+	if challengeNotFound(err) {
+		status = http.StatusNotFound
+		message = "The request has expired or does not exist"
+	}
+
+	_ = s.renderer.Render(w, status, "error", map[string]interface{}{
+		"ErrorMessage": message,
+	})
+}
+
+// handledRedirect returns where the user should go if
+// the request has already been handled, or nil otherwise.
+func handledRedirect(err error) *string {
+	var (
+		loginGone   *hydraAdmin.GetLoginRequestGone
+		consentGone *hydraAdmin.GetConsentRequestGone
+		logoutGone  *hydraAdmin.GetLogoutRequestGone
+	)
+
+	switch {
+	case errors.As(err, &loginGone):
+		return loginGone.GetPayload().RedirectTo
+	case errors.As(err, &consentGone):
+		return consentGone.GetPayload().RedirectTo
+	case errors.As(err, &logoutGone):
+		return logoutGone.GetPayload().RedirectTo
+	}
+
+	return nil
+}
+
+func challengeNotFound(err error) bool {
+	var (
+		loginNotFound   *hydraAdmin.GetLoginRequestNotFound
+		consentNotFound *hydraAdmin.GetConsentRequestNotFound
+		logoutNotFound  *hydraAdmin.GetLogoutRequestNotFound
+	)
+
+	return errors.As(err, &loginNotFound) || errors.As(err, &consentNotFound) || errors.As(err, &logoutNotFound)
+}
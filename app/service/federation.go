@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/session"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const callbackPath = "/authentication/login/callback"
+
+// federateLogin sends the user to the federated connection they chose.
+func (s *Service) federateLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		loginChallenge = strings.TrimSpace(r.PostFormValue(loginChallengeKey))
+		connection     = strings.TrimSpace(r.PostFormValue(connectionKey))
+	)
+
+	if loginChallenge == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), loginChallenge)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get login request info")
+		return
+	}
+
+	s.federate(w, r, req, connection)
+}
+
+// federate sends the user to the federated connection,
+// or back to the login page if the handoff fails.
+func (s *Service) federate(w http.ResponseWriter, r *http.Request, req *models.LoginRequest, connection string) {
+	redirectTo, err := s.handoff(w, r, req, connection)
+	if err != nil {
+		s.loginFailed(w, r, req, "", err)
+		return
+	}
+
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+// handoff starts the login at the federated connection, keeping the
+// flow in a cookie until the user is back at the callback, and returns
+// where to send the user.
+func (s *Service) handoff(w http.ResponseWriter, r *http.Request, req *models.LoginRequest, connection string) (string, error) {
+	conn, ok := s.selectConnection(req.Client, connection)
+	if !ok {
+		return "", errUnknownConnection
+	}
+
+	f, ok := conn.Provider.(provider.Federated)
+	if !ok {
+		return "", errUnknownConnection
+	}
+
+	h, err := f.Handoff(r.Context(), s.config.CallbackURL)
+	if err != nil {
+		log.WithError(err).WithField("connection", conn.Name).Error("failed to hand off login")
+		return "", err
+	}
+
+	if err := s.sessions.SetFlow(w, &session.Flow{
+		Challenge:     *req.Challenge,
+		Connection:    conn.Name,
+		State:         h.State,
+		Saved:         h.Saved,
+		CrossSitePost: h.CrossSitePost,
+	}); err != nil {
+		log.WithError(err).Error("failed to save login flow")
+		return "", err
+	}
+
+	return h.URL, nil
+}
+
+// federationCallback completes the login once the user is back from the
+// federated connection.
+func (s *Service) federationCallback(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flow, err := s.sessions.TakeFlow(w, r)
+	if err != nil {
+		s.flowExpired(w)
+		return
+	}
+
+	q := r.URL.Query()
+
+	s.resumeFlow(w, r, flow, q.Get("state"), q)
+}
+
+// resumeFlow checks that the user is back from the login in progress and
+// gives the federated connection the parameters it was called back with,
+// together with what it saved when handing off.
+func (s *Service) resumeFlow(w http.ResponseWriter, r *http.Request, flow *session.Flow, state string, params url.Values) {
+	if subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		s.flowMismatch(w)
+		return
+	}
+
+	req, err := s.getLoginRequest(r.Context(), flow.Challenge)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get login request info")
+		return
+	}
+
+	conn, ok := s.selectConnection(req.Client, flow.Connection)
+	if !ok {
+		s.loginFailed(w, r, req, "", errUnknownConnection)
+		return
+	}
+
+	creds := make(provider.Credentials, len(params)+len(flow.Saved))
+	for k := range params {
+		creds[k] = params.Get(k)
+	}
+
+	for k, v := range flow.Saved {
+		creds[k] = v
+	}
+
+	i, err := conn.Provider.Provide(r.Context(), creds)
+	if err != nil {
+		log.WithError(err).WithField("connection", conn.Name).Warn("failed to authenticate with federated connection")
+
+		s.loginFailed(w, r, req, "", err)
+
+		return
+	}
+
+	if err := requireLevel(req, i); err != nil {
+		s.loginFailed(w, r, req, "", err)
+		return
+	}
+
+	redirectTo, err := s.authenticated(w, r, req, conn.Name, i, false)
+	s.complete(w, r, redirectTo, err, "Failed to complete login request")
+}
+
+func (s *Service) flowExpired(w http.ResponseWriter) {
+	_ = s.renderer.Render(w, http.StatusBadRequest, "error", map[string]interface{}{
+		"ErrorMessage": "The sign-in has expired or was already completed, please start over",
+	})
+}
+
+func (s *Service) flowMismatch(w http.ResponseWriter) {
+	_ = s.renderer.Render(w, http.StatusBadRequest, "error", map[string]interface{}{
+		"ErrorMessage": "The sign-in does not match the one in progress, please start over",
+	})
+}
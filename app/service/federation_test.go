@@ -0,0 +1,111 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mpraski/identity-provider/app/gateway/oidctest"
+	"github.com/mpraski/identity-provider/app/hydratest"
+	"github.com/ory/hydra-client-go/models"
+)
+
+func TestFederatedLogin(t *testing.T) {
+	cases := []struct {
+		name      string
+		tamper    oidctest.Tamper
+		state     string
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:      "accepted",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "signature tampered",
+			tamper:   oidctest.Tamper{Signature: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "nonce tampered",
+			tamper:   oidctest.Tamper{Nonce: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "audience tampered",
+			tamper:   oidctest.Tamper{Audience: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "issuer tampered",
+			tamper:   oidctest.Tamper{Issuer: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "token expired",
+			tamper:   oidctest.Tamper{Expired: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "state mismatched",
+			state:    "forged-state",
+			status:   http.StatusBadRequest,
+			contains: "does not match the one in progress",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			upstream, issuer := federatedConnection(t, "upstream")
+			issuer.SetTamper(c.tamper)
+
+			ts := newTestService(t, Config{}, upstream)
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}})
+
+			resp, body := ts.post(t, "/authentication/login/federate", url.Values{
+				loginChallengeKey: {challenge},
+				connectionKey:     {"upstream"},
+			})
+			checkResponse(t, resp, body, http.StatusFound, issuer.URL+"/authorize?", "")
+
+			// The issuer sends the user straight back to the callback.
+			resp, _ = ts.visit(t, resp.Header.Get("Location"))
+
+			callback, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil || callback.Path != callbackPath {
+				t.Fatalf("issuer redirected to %q", resp.Header.Get("Location"))
+			}
+
+			if c.state != "" {
+				q := callback.Query()
+				q.Set("state", c.state)
+				callback.RawQuery = q.Encode()
+			}
+
+			resp, body = ts.visit(t, callback.String())
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			call := checkOutcome(t, ts.hydra, challenge, c.operation, "")
+
+			if c.operation != hydratest.OpAcceptLoginRequest {
+				return
+			}
+
+			accept, _ := call.Body.(*models.AcceptLoginRequest)
+			if accept == nil || *accept.Subject != "upstream:jdoe" {
+				t.Fatalf("accepted with %+v", call.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/session"
+	"github.com/mpraski/identity-provider/app/template"
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+	"github.com/ory/hydra-client-go/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// loginFailure tells how to react to a failed authentication:
+	// either by rejecting the login request, or by letting
+	// the user try again.
+	loginFailure struct {
+		reject  *rejection
+		status  int
+		code    string
+		message string
+	}
+
+	// consentDecision is what the user decided about a consent request.
+	consentDecision struct {
+		deny     bool
+		remember bool
+		scope    []string
+		audience []string
+	}
+)
+
+var errStepUpRequired = errors.New("authentication is too weak for the client")
+
+// classifyLoginError decides how to react to the authentication error,
+// describing it to the user in their language.
+func classifyLoginError(err error, m *template.Messages) loginFailure {
+	switch {
+	case errors.Is(err, provider.ErrAccountDisabled):
+		return loginFailure{reject: &rejectAccountDisabled}
+	case errors.Is(err, errUnknownConnection):
+		return loginFailure{
+			status:  http.StatusBadRequest,
+			code:    "invalid_connection",
+			message: m.ChooseConnection,
+		}
+	// Locked accounts and those requiring another factor are only told
+	// apart from wrong credentials in the logs, as telling the user would
+	// confirm that the account exists, and for the latter, the password.
+	case errors.Is(err, provider.ErrAccountLocked), errors.Is(err, provider.ErrMFARequired):
+		log.WithError(err).Warn("sign-in refused")
+
+		fallthrough
+	case errors.Is(err, provider.ErrInvalidCredentials):
+		return loginFailure{
+			status:  http.StatusUnauthorized,
+			code:    "invalid_credentials",
+			message: m.InvalidCredentials,
+		}
+	case errors.Is(err, provider.ErrValidation):
+		return loginFailure{
+			status:  http.StatusBadRequest,
+			code:    "invalid_input",
+			message: m.InvalidInput,
+		}
+	case errors.Is(err, errStepUpRequired):
+		return loginFailure{
+			status:  http.StatusForbidden,
+			code:    "step_up_required",
+			message: m.StepUpRequired,
+		}
+	case errors.Is(err, provider.ErrUpstreamInvalid):
+		return loginFailure{
+			status:  http.StatusBadRequest,
+			code:    "invalid_upstream_response",
+			message: m.SignInFailed,
+		}
+	case errors.Is(err, provider.ErrUpstreamUnavailable):
+		return loginFailure{
+			status:  http.StatusServiceUnavailable,
+			code:    "upstream_unavailable",
+			message: m.Unavailable,
+		}
+	default:
+		return loginFailure{
+			status:  http.StatusInternalServerError,
+			code:    "authentication_failed",
+			message: m.SignInFailed,
+		}
+	}
+}
+
+// skipLogin completes the login request right away if the remembered
+// login session is good enough for the client, or if the user can not
+// sign in, because the client asked for no prompt or allows no connection.
+func (s *Service) skipLogin(r *http.Request, req *models.LoginRequest) (string, bool, error) {
+	var (
+		skip = req.Skip != nil && *req.Skip
+		o    = loginParams(req)
+		sess *session.Session
+	)
+
+	if skip {
+		sess, skip = s.rememberedSession(r, req, &o)
+	}
+
+	if skip {
+		redirectTo, err := s.acceptLoginRequest(r.Context(), *req.Challenge, &models.AcceptLoginRequest{
+			Subject: req.Subject,
+			Acr:     sess.ACR,
+			Context: loginContext(sess.Connection, nil),
+		})
+		if err != nil {
+			log.WithError(err).Error("failed to accept login request")
+
+			redirectTo, err = s.rejectLoginRequest(r.Context(), *req.Challenge, rejectServerError)
+		}
+
+		return redirectTo, true, err
+	}
+
+	// The client asked not to show any UI, yet the user has to sign in.
+	if o.prompts(promptNone) {
+		redirectTo, err := s.rejectLoginRequest(r.Context(), *req.Challenge, rejectLoginRequired)
+		return redirectTo, true, err
+	}
+
+	if len(s.allowedConnections(req.Client)) == 0 {
+		log.WithField("client_id", clientID(req.Client)).Error("client allows no known connection")
+
+		redirectTo, err := s.rejectLoginRequest(r.Context(), *req.Challenge, rejectNoConnection)
+
+		return redirectTo, true, err
+	}
+
+	return "", false, nil
+}
+
+// requireLevel checks that the authentication is strong enough for the
+// client. If it is not, the user is asked to sign in again with a stronger
+// connection, rather than having the login request rejected outright.
+func requireLevel(req *models.LoginRequest, a *provider.AuthenticatedIdentity) error {
+	o := loginParams(req)
+	if required, ok := o.requiredLevel(); ok && a.Level < required {
+		return fmt.Errorf("%w: %s is required, %s was achieved", errStepUpRequired, required.ACR(), a.Level.ACR())
+	}
+
+	return nil
+}
+
+// authenticated accepts the login request on behalf of the subject
+// authenticated with the connection. Hydra sets the acr claim from the
+// assurance level. The amr claim is not set: the accept login request of
+// the Hydra client in use has no field for it, and Hydra reserves the
+// claim, so the methods are only kept in the session of our own.
+func (s *Service) authenticated(
+	w http.ResponseWriter,
+	r *http.Request,
+	req *models.LoginRequest,
+	connection string,
+	a *provider.AuthenticatedIdentity,
+	remember bool,
+) (string, error) {
+	redirectTo, err := s.acceptLoginRequest(r.Context(), *req.Challenge, &models.AcceptLoginRequest{
+		Subject:     &a.Subject,
+		Remember:    remember,
+		RememberFor: rememberFor,
+		Acr:         a.Level.ACR(),
+		Context:     loginContext(connection, a.Traits),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to accept login request")
+
+		return s.rejectLoginRequest(r.Context(), *req.Challenge, rejectServerError)
+	}
+
+	s.sessions.Set(w, &session.Session{
+		Subject:    a.Subject,
+		Connection: connection,
+		AuthTime:   a.AuthTime,
+		ACR:        a.Level.ACR(),
+		AMR:        a.Methods,
+	})
+
+	return redirectTo, nil
+}
+
+// skipConsent accepts the consent request right away, granting everything
+// requested, if Hydra remembers the consent or the client is trusted.
+func (s *Service) skipConsent(ctx context.Context, req *models.ConsentRequest) (string, bool, error) {
+	trusted := s.isTrustedClient(req.Client)
+
+	if !req.Skip && !trusted {
+		return "", false, nil
+	}
+
+	if !req.Skip {
+		log.WithFields(log.Fields{
+			"client_id": clientID(req.Client),
+			"subject":   req.Subject,
+		}).Warn("consent auto-approved for trusted client")
+	}
+
+	session, err := s.session(ctx, req, req.RequestedScope)
+	if err != nil {
+		log.WithError(err).Error("failed to build consent session")
+
+		redirectTo, err := s.rejectConsentRequest(ctx, *req.Challenge, rejectServerError)
+
+		return redirectTo, true, err
+	}
+
+	redirectTo, err := s.acceptConsentRequest(ctx, *req.Challenge, &models.AcceptConsentRequest{
+		GrantAccessTokenAudience: req.RequestedAccessTokenAudience,
+		GrantScope:               req.RequestedScope,
+		Session:                  session,
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to accept consent request")
+
+		redirectTo, err = s.rejectConsentRequest(ctx, *req.Challenge, rejectServerError)
+	}
+
+	return redirectTo, true, err
+}
+
+// decideConsent completes the consent request with the decision of the user,
+// granting no more than what was requested.
+func (s *Service) decideConsent(ctx context.Context, req *models.ConsentRequest, d *consentDecision) (string, error) {
+	if d.deny {
+		return s.rejectConsentRequest(ctx, *req.Challenge, rejectAccessDenied)
+	}
+
+	grantScope, unrequested := s.grantScopes(req.RequestedScope, d.scope)
+	if len(unrequested) != 0 {
+		log.WithFields(log.Fields{
+			"client_id":   clientID(req.Client),
+			"subject":     req.Subject,
+			"unrequested": unrequested,
+		}).Warn("consent form attempted to grant unrequested scopes")
+
+		return s.rejectConsentRequest(ctx, *req.Challenge, rejectInvalidScope)
+	}
+
+	grantAudience, unrequested := s.grantAudiences(req.RequestedAccessTokenAudience, d.audience)
+	if len(unrequested) != 0 {
+		log.WithFields(log.Fields{
+			"client_id":   clientID(req.Client),
+			"subject":     req.Subject,
+			"unrequested": unrequested,
+		}).Warn("consent form attempted to grant unrequested audiences")
+
+		return s.rejectConsentRequest(ctx, *req.Challenge, rejectInvalidAudience)
+	}
+
+	session, err := s.session(ctx, req, grantScope)
+	if err != nil {
+		log.WithError(err).Error("failed to build consent session")
+
+		return s.rejectConsentRequest(ctx, *req.Challenge, rejectServerError)
+	}
+
+	redirectTo, err := s.acceptConsentRequest(ctx, *req.Challenge, &models.AcceptConsentRequest{
+		GrantAccessTokenAudience: grantAudience,
+		GrantScope:               grantScope,
+		Session:                  session,
+		Remember:                 d.remember,
+		RememberFor:              int64(s.consentRememberFor(req.Client) / time.Second),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to accept consent request")
+
+		return s.rejectConsentRequest(ctx, *req.Challenge, rejectServerError)
+	}
+
+	return redirectTo, nil
+}
+
+func (s *Service) getLoginRequest(ctx context.Context, challenge string) (*models.LoginRequest, error) {
+	params := hydraAdmin.NewGetLoginRequestParams()
+	params.WithContext(ctx)
+	params.SetLoginChallenge(challenge)
+
+	req, err := s.hydra.GetLoginRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return req.GetPayload(), nil
+}
+
+func (s *Service) getConsentRequest(ctx context.Context, challenge string) (*models.ConsentRequest, error) {
+	params := hydraAdmin.NewGetConsentRequestParams()
+	params.WithContext(ctx)
+	params.SetConsentChallenge(challenge)
+
+	req, err := s.hydra.GetConsentRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return req.GetPayload(), nil
+}
+
+func (s *Service) acceptLoginRequest(ctx context.Context, challenge string, body *models.AcceptLoginRequest) (string, error) {
+	params := hydraAdmin.NewAcceptLoginRequestParams()
+	params.WithContext(ctx)
+	params.SetLoginChallenge(challenge)
+	params.SetBody(body)
+
+	reqAccept, err := s.hydra.AcceptLoginRequest(params)
+	if err != nil {
+		return "", err
+	}
+
+	return *reqAccept.GetPayload().RedirectTo, nil
+}
+
+func (s *Service) acceptConsentRequest(
+	ctx context.Context,
+	challenge string,
+	body *models.AcceptConsentRequest,
+) (string, error) {
+	params := hydraAdmin.NewAcceptConsentRequestParams()
+	params.WithContext(ctx)
+	params.SetConsentChallenge(challenge)
+	params.SetBody(body)
+
+	reqAccept, err := s.hydra.AcceptConsentRequest(params)
+	if err != nil {
+		return "", err
+	}
+
+	return *reqAccept.GetPayload().RedirectTo, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/csrf"
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+)
+
+func (s *Service) beginLogout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	challenge := strings.TrimSpace(r.URL.Query().Get(logoutChallengeKey))
+	if challenge == "" {
+		_ = s.renderer.Render(w, http.StatusBadRequest, "error", map[string]interface{}{
+			"ErrorMessage": "Expected a logout challenge to be set but received none",
+		})
+
+		return
+	}
+
+	params := hydraAdmin.NewGetLogoutRequestParams()
+	params.WithContext(r.Context())
+	params.SetLogoutChallenge(challenge)
+
+	req, err := s.hydra.GetLogoutRequest(params)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get logout request info")
+		return
+	}
+
+	// The relying party has already asked the user, so there
+	// is no point in asking for confirmation again.
+	if req.GetPayload().RpInitiated {
+		s.acceptLogout(w, r, challenge)
+		return
+	}
+
+	_ = s.renderer.Render(w, http.StatusOK, "logout", csrf.WithToken(r, map[string]interface{}{
+		"LogoutChallenge": challenge,
+	}))
+}
+
+func (s *Service) completeLogout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		logoutChallenge = strings.TrimSpace(r.PostFormValue(logoutChallengeKey))
+		action          = strings.TrimSpace(r.PostFormValue(actionKey))
+	)
+
+	if logoutChallenge == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	params := hydraAdmin.NewGetLogoutRequestParams()
+	params.WithContext(r.Context())
+	params.SetLogoutChallenge(logoutChallenge)
+
+	req, err := s.hydra.GetLogoutRequest(params)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get logout request info")
+		return
+	}
+
+	if action == actionAccept {
+		s.acceptLogout(w, r, logoutChallenge)
+		return
+	}
+
+	rejectParams := hydraAdmin.NewRejectLogoutRequestParams()
+	rejectParams.WithContext(r.Context())
+	rejectParams.SetLogoutChallenge(logoutChallenge)
+
+	if _, err := s.hydra.RejectLogoutRequest(rejectParams); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if c := req.GetPayload().Client; c != nil && c.ClientURI != "" {
+		http.Redirect(w, r, c.ClientURI, http.StatusFound)
+		return
+	}
+
+	_ = s.renderer.Render(w, http.StatusOK, "logout", map[string]interface{}{
+		"LogoutMessage": "You are still signed in",
+	})
+}
+
+func (s *Service) acceptLogout(w http.ResponseWriter, r *http.Request, challenge string) {
+	params := hydraAdmin.NewAcceptLogoutRequestParams()
+	params.WithContext(r.Context())
+	params.SetLogoutChallenge(challenge)
+
+	reqAccept, err := s.hydra.AcceptLogoutRequest(params)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+
+	s.sessions.Clear(w)
+
+	http.Redirect(w, r, *reqAccept.GetPayload().RedirectTo, http.StatusFound)
+}
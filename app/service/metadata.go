@@ -0,0 +1,74 @@
+package service
+
+import (
+	"time"
+
+	"github.com/ory/hydra-client-go/models"
+)
+
+const (
+	metadataConsentRememberFor = "consent_remember_for"
+	metadataTrusted            = "trusted"
+	metadataConnections        = "connections"
+)
+
+func clientMetadata(c *models.OAuth2Client, key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	m, ok := c.Metadata.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	v, ok := m[key]
+
+	return v, ok
+}
+
+// consentRememberFor returns for how long the consent should be
+// remembered, honoring the client's consent_remember_for metadata,
+// which is either a number of seconds or a duration string.
+func (s *Service) consentRememberFor(c *models.OAuth2Client) time.Duration {
+	v, ok := clientMetadata(c, metadataConsentRememberFor)
+	if !ok {
+		return s.config.ConsentRememberFor
+	}
+
+	switch t := v.(type) {
+	case float64:
+		if t >= 0 {
+			return time.Duration(t) * time.Second
+		}
+	case string:
+		if d, err := time.ParseDuration(t); err == nil && d >= 0 {
+			return d
+		}
+	}
+
+	return s.config.ConsentRememberFor
+}
+
+// isTrustedClient checks whether the client is a first-party
+// one, either configured as such or flagged in its metadata.
+func (s *Service) isTrustedClient(c *models.OAuth2Client) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, id := range s.config.TrustedClients {
+		if id == c.ClientID {
+			return true
+		}
+	}
+
+	v, ok := clientMetadata(c, metadataTrusted)
+	if !ok {
+		return false
+	}
+
+	trusted, ok := v.(bool)
+
+	return ok && trusted
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/csrf"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/session"
+	"github.com/mpraski/identity-provider/app/template"
+	"github.com/ory/hydra-client-go/models"
+)
+
+const (
+	promptKey   = "prompt"
+	maxAgeKey   = "max_age"
+	promptLogin = "login"
+	promptNone  = "none"
+	traitsKey   = "traits"
+)
+
+// oidcParams are the OpenID Connect authentication
+// request parameters relevant to the login page.
+type oidcParams struct {
+	prompt    []string
+	maxAge    time.Duration
+	hasMaxAge bool
+	loginHint string
+	uiLocales []string
+	acrValues []string
+}
+
+func loginParams(req *models.LoginRequest) oidcParams {
+	var o oidcParams
+
+	if c := req.OidcContext; c != nil {
+		o.loginHint = c.LoginHint
+		o.uiLocales = c.UILocales
+		o.acrValues = c.AcrValues
+	}
+
+	if req.RequestURL == nil {
+		return o
+	}
+
+	u, err := url.Parse(*req.RequestURL)
+	if err != nil {
+		return o
+	}
+
+	q := u.Query()
+
+	o.prompt = strings.Fields(q.Get(promptKey))
+
+	if v := q.Get(maxAgeKey); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			o.maxAge = time.Duration(n) * time.Second
+			o.hasMaxAge = true
+		}
+	}
+
+	return o
+}
+
+func (o *oidcParams) prompts(prompt string) bool {
+	for _, p := range o.prompt {
+		if p == prompt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requiredLevel returns the lowest assurance level out of the
+// requested acr_values, any of which satisfies the client.
+func (o *oidcParams) requiredLevel() (provider.Level, bool) {
+	var (
+		required provider.Level
+		found    bool
+	)
+
+	for _, acr := range o.acrValues {
+		l, ok := provider.ParseLevel(acr)
+		if !ok {
+			continue
+		}
+
+		if !found || l < required {
+			required, found = l, true
+		}
+	}
+
+	return required, found
+}
+
+// rememberedSession returns the session of the subject of a remembered
+// login, if it is good enough for the client. Otherwise, the user has to
+// sign in again. Without a session of our own the connection, time and
+// method of the last authentication are unknown, so it always forces it.
+// So does a connection the client does not allow, as the client would
+// otherwise be signed into with a connection it does not trust.
+func (s *Service) rememberedSession(r *http.Request, req *models.LoginRequest, o *oidcParams) (*session.Session, bool) {
+	if o.prompts(promptLogin) {
+		return nil, false
+	}
+
+	sess, err := s.sessions.Get(r)
+	if err != nil || sess.Subject != *req.Subject {
+		return nil, false
+	}
+
+	if sess.Connection == "" {
+		return nil, false
+	}
+
+	if _, ok := s.selectConnection(req.Client, sess.Connection); !ok {
+		return nil, false
+	}
+
+	// Without an authentication time, it can not be told how long
+	// ago the subject authenticated, so any maximum age is exceeded.
+	if o.hasMaxAge && (sess.AuthTime.IsZero() || time.Since(sess.AuthTime) > o.maxAge) {
+		return nil, false
+	}
+
+	if required, ok := o.requiredLevel(); ok {
+		if l, ok := provider.ParseLevel(sess.ACR); !ok || l < required {
+			return nil, false
+		}
+	}
+
+	return sess, true
+}
+
+// loginContext carries the connection and, if known, the traits of the
+// subject over to the consent request, as Hydra does not keep track of them.
+func loginContext(connection string, traits *provider.Traits) map[string]interface{} {
+	c := make(map[string]interface{})
+
+	if connection != "" {
+		c[connectionKey] = connection
+	}
+
+	if traits != nil {
+		c[traitsKey] = traits
+	}
+
+	return c
+}
+
+func contextConnection(ctx interface{}) string {
+	m, ok := ctx.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	connection, _ := m[connectionKey].(string)
+
+	return connection
+}
+
+func (s *Service) renderLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	req *models.LoginRequest,
+	data map[string]interface{},
+) {
+	var (
+		o                = loginParams(req)
+		locale, messages = template.Localize(o.uiLocales)
+	)
+
+	if _, ok := data["Email"]; !ok {
+		data["Email"] = o.loginHint
+	}
+
+	// Once the connection can be discovered from the email,
+	// only its form is shown. Until then, only the email is asked for.
+	connections := s.allowedConnections(req.Client)
+	if identifierFirst(connections) {
+		email, _ := data["Email"].(string)
+
+		if c, ok := discoverConnection(connections, email); ok {
+			connections = []*provider.Connection{c}
+		} else {
+			data["Identify"] = true
+		}
+	}
+
+	data["LoginChallenge"] = *req.Challenge
+	data["Connections"] = loginConnections(connections)
+	data["Locale"] = locale
+	data["Messages"] = messages
+
+	_ = s.renderer.Render(w, status, "login", csrf.WithToken(r, data))
+}
+
+// contextTraits returns the traits carried over from the login request.
+// Hydra hands the context back as decoded JSON, so it is decoded again.
+func contextTraits(ctx interface{}) (*provider.Traits, bool) {
+	m, ok := ctx.(map[string]interface{})
+	if !ok || m[traitsKey] == nil {
+		return nil, false
+	}
+
+	b, err := json.Marshal(m[traitsKey])
+	if err != nil {
+		return nil, false
+	}
+
+	var traits provider.Traits
+	if err := json.Unmarshal(b, &traits); err != nil {
+		return nil, false
+	}
+
+	return &traits, true
+}
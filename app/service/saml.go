@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// The SAML endpoints are under the login path, so that
+// the flow cookie is sent to the assertion consumer service.
+const (
+	samlMetadataPath = "/authentication/login/saml/:connection/metadata"
+	samlACSPath      = "/authentication/login/saml/:connection/acs"
+)
+
+// samlMetadata publishes the service provider metadata
+// of the SAML connection for its identity provider.
+func (s *Service) samlMetadata(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sp, ok := s.samlProvider(p.ByName(connectionKey))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		log.WithError(err).Error("failed to create SAML metadata")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(metadata)
+}
+
+// samlACS is the assertion consumer service, which the identity provider
+// posts its response to. The response has to answer the request of the
+// flow in progress for the connection, which the relay state refers to.
+func (s *Service) samlACS(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if _, ok := s.samlProvider(p.ByName(connectionKey)); !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	flow, err := s.sessions.TakeFlow(w, r)
+	if err != nil {
+		s.flowExpired(w)
+		return
+	}
+
+	if flow.Connection != p.ByName(connectionKey) {
+		s.flowMismatch(w)
+		return
+	}
+
+	s.resumeFlow(w, r, flow, r.PostForm.Get("RelayState"), r.PostForm)
+}
+
+func (s *Service) samlProvider(name string) (*provider.SAMLProvider, bool) {
+	conn, ok := s.connections.Lookup(name)
+	if !ok {
+		return nil, false
+	}
+
+	sp, ok := conn.Provider.(*provider.SAMLProvider)
+
+	return sp, ok
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/mpraski/identity-provider/app/gateway/saml"
+	"github.com/mpraski/identity-provider/app/gateway/samltest"
+	"github.com/mpraski/identity-provider/app/hydratest"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/ory/hydra-client-go/models"
+)
+
+var (
+	samlResponsePattern = regexp.MustCompile(`name="SAMLResponse" value="([^"]+)"`)
+	relayStatePattern   = regexp.MustCompile(`name="RelayState" value="([^"]*)"`)
+)
+
+// insecureJar keeps the cookies meant for https only, as the
+// flow cookie of a SAML connection is, when sending them over http.
+type insecureJar struct {
+	http.CookieJar
+}
+
+func (j insecureJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		c.Secure = false
+	}
+
+	j.CookieJar.SetCookies(u, cookies)
+}
+
+func TestSAMLLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		tamper     samltest.Tamper
+		subject    string
+		relayState *string
+		status     int
+		location   string
+		contains   string
+		operation  string
+	}{
+		{
+			name:      "accepted",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "unsigned",
+			tamper:   samltest.Tamper{Unsigned: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "audience tampered",
+			tamper:   samltest.Tamper{Audience: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "expired",
+			tamper:   samltest.Tamper{Expired: true},
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:     "subject attribute missing",
+			subject:  "urn:oid:0.9.2342.19200300.100.1.1",
+			status:   http.StatusBadRequest,
+			contains: "Failed to sign in",
+		},
+		{
+			name:       "relay state mismatched",
+			relayState: stringPtr("forged-state"),
+			status:     http.StatusBadRequest,
+			contains:   "does not match the one in progress",
+		},
+		{
+			name:       "relay state missing",
+			relayState: stringPtr(""),
+			status:     http.StatusBadRequest,
+			contains:   "does not match the one in progress",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idp := samltest.NewIdentityProvider()
+			t.Cleanup(idp.Close)
+
+			idp.SetUser("jdoe", map[string][]string{provider.DefaultAttributes.Email: {"jdoe@example.com"}})
+			idp.SetTamper(c.tamper)
+
+			// The response is posted to the service directly, so the
+			// ACS URL only has to be the one the response is checked against.
+			sp, err := saml.New("https://idp.test/saml", "https://idp.test/authentication/login/saml/corp/acs", idp.Metadata())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			attributes := provider.DefaultAttributes
+			attributes.Subject = c.subject
+
+			ts := newTestService(t, Config{}, provider.Connection{
+				Name:     "corp",
+				Provider: provider.NewSAMLProvider(sp, attributes, "corp:"),
+			})
+			ts.client.Jar = insecureJar{ts.client.Jar}
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}})
+
+			resp, body := ts.post(t, "/authentication/login/federate", url.Values{
+				loginChallengeKey: {challenge},
+				connectionKey:     {"corp"},
+			})
+			checkResponse(t, resp, body, http.StatusFound, idp.Metadata().SSOURL+"?", "")
+
+			// The identity provider answers with a form posting back to the service.
+			_, body = ts.visit(t, resp.Header.Get("Location"))
+
+			samlResponse := samlResponsePattern.FindStringSubmatch(body)
+			relayState := relayStatePattern.FindStringSubmatch(body)
+
+			if samlResponse == nil || relayState == nil {
+				t.Fatalf("identity provider answered with %s", body)
+			}
+
+			form := url.Values{
+				"SAMLResponse": {html.UnescapeString(samlResponse[1])},
+				"RelayState":   {html.UnescapeString(relayState[1])},
+			}
+
+			if c.relayState != nil {
+				form.Set("RelayState", *c.relayState)
+			}
+
+			resp, err = ts.client.PostForm(ts.URL+"/authentication/login/saml/corp/acs", form)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkResponse(t, resp, readBody(t, resp), c.status, c.location, c.contains)
+			call := checkOutcome(t, ts.hydra, challenge, c.operation, "")
+
+			if c.operation != hydratest.OpAcceptLoginRequest {
+				return
+			}
+
+			accept, _ := call.Body.(*models.AcceptLoginRequest)
+			if accept == nil || *accept.Subject != "corp:jdoe" {
+				t.Fatalf("accepted with %+v", call.Body)
+			}
+		})
+	}
+}
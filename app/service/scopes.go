@@ -0,0 +1,119 @@
+package service
+
+import (
+	"sort"
+)
+
+type (
+	consentScope struct {
+		Scope       string `json:"scope"`
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Sensitive   bool   `json:"sensitive"`
+		Mandatory   bool   `json:"mandatory"`
+		position    int
+	}
+
+	consentScopeGroup struct {
+		Name   string         `json:"name,omitempty"`
+		Scopes []consentScope `json:"scopes"`
+	}
+)
+
+func (s *Service) isMandatoryScope(scope string) bool {
+	for _, m := range s.config.MandatoryScopes {
+		if m == scope {
+			return true
+		}
+	}
+
+	_, c, ok := s.config.Catalog.Scopes.Lookup(scope)
+
+	return ok && c.Required
+}
+
+// consentScopeGroups describes the requested scopes using the catalog.
+// Scopes are ordered as listed in the catalog, with the unknown ones last,
+// and groups are ordered by the position of their first scope.
+func (s *Service) consentScopeGroups(requested []string) []consentScopeGroup {
+	var (
+		groups  []consentScopeGroup
+		indexes = make(map[string]int)
+		scopes  = make([]consentScope, 0, len(requested))
+		unknown = len(s.config.Catalog.Scopes)
+	)
+
+	for _, scope := range requested {
+		c := consentScope{
+			Scope:     scope,
+			Title:     scope,
+			Mandatory: s.isMandatoryScope(scope),
+		}
+
+		if i, d, ok := s.config.Catalog.Scopes.Lookup(scope); ok {
+			c.Title = d.Title
+			c.Description = d.Description
+			c.Sensitive = d.Sensitive
+			c.position = i
+		} else {
+			c.position = unknown
+			unknown++
+		}
+
+		scopes = append(scopes, c)
+	}
+
+	sort.SliceStable(scopes, func(i, j int) bool {
+		return scopes[i].position < scopes[j].position
+	})
+
+	for _, c := range scopes {
+		var group string
+		if _, d, ok := s.config.Catalog.Scopes.Lookup(c.Scope); ok {
+			group = d.Group
+		}
+
+		i, ok := indexes[group]
+		if !ok {
+			i = len(groups)
+			indexes[group] = i
+			groups = append(groups, consentScopeGroup{Name: group})
+		}
+
+		groups[i].Scopes = append(groups[i].Scopes, c)
+	}
+
+	return groups
+}
+
+// grantScopes intersects the submitted scopes with the requested ones,
+// always granting the requested scopes which are mandatory. Submitted
+// scopes which were never requested are returned separately.
+func (s *Service) grantScopes(requested, submitted []string) (granted, unrequested []string) {
+	return intersect(requested, submitted, s.isMandatoryScope)
+}
+
+func intersect(requested, submitted []string, always func(string) bool) (granted, unrequested []string) {
+	isSubmitted := make(map[string]bool, len(submitted))
+	isRequested := make(map[string]bool, len(requested))
+
+	for _, v := range submitted {
+		isSubmitted[v] = true
+	}
+
+	for _, v := range requested {
+		isRequested[v] = true
+
+		if isSubmitted[v] || (always != nil && always(v)) {
+			granted = append(granted, v)
+		}
+	}
+
+	for _, v := range submitted {
+		if !isRequested[v] {
+			unrequested = append(unrequested, v)
+		}
+	}
+
+	return granted, unrequested
+}
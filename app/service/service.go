@@ -4,37 +4,83 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/catalog"
+	"github.com/mpraski/identity-provider/app/claims"
 	"github.com/mpraski/identity-provider/app/csrf"
 	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/session"
 	"github.com/mpraski/identity-provider/app/template"
 	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
 	"github.com/ory/hydra-client-go/models"
 )
 
-type Service struct {
-	renderer *template.Renderer
-	identity provider.Provider
-	hydra    hydraAdmin.ClientService
-}
+type (
+	Service struct {
+		renderer    *template.Renderer
+		connections *provider.Registry
+		hydra       hydraAdmin.ClientService
+		sessions    *session.Store
+		config      Config
+	}
+
+	Config struct {
+		// ClaimRules map identity traits onto token claims
+		// in addition to the standard OIDC claims.
+		ClaimRules claims.Rules
+		// MandatoryScopes are always granted when requested
+		// and can not be unticked on the consent page.
+		MandatoryScopes []string
+		// Catalog describes the scopes and audiences
+		// shown on the consent page.
+		Catalog catalog.Catalog
+		// ConsentRememberFor is for how long a remembered consent
+		// is valid, unless the client overrides it. Zero means forever.
+		ConsentRememberFor time.Duration
+		// TrustedClients are first-party clients
+		// for which consent is given automatically.
+		TrustedClients []string
+		// AccountLoginURL is where users without a session
+		// are sent when visiting the self-service pages.
+		AccountLoginURL string
+		// AdminToken is the bearer token required by the admin handler,
+		// which rejects every request if it is empty.
+		AdminToken string
+		// CallbackURL is the public URL of the callback federated
+		// connections send users back to. It is required as soon
+		// as a federated connection is registered.
+		CallbackURL string
+	}
+)
 
 const (
 	loginChallengeKey   = "login_challenge"
 	consentChallengeKey = "consent_challenge"
+	logoutChallengeKey  = "logout_challenge"
 	grantScopeKey       = "grant_scope"
+	grantAudienceKey    = "grant_audience"
+	rememberKey         = "remember"
+	actionKey           = "action"
+	actionAccept        = "accept"
+	actionDeny          = "deny"
 	rememberFor         = 3600
 )
 
 func New(
 	renderer *template.Renderer,
-	identity provider.Provider,
+	connections *provider.Registry,
 	hydra hydraAdmin.ClientService,
+	sessions *session.Store,
+	config Config,
 ) *Service {
 	return &Service{
-		renderer: renderer,
-		identity: identity,
-		hydra:    hydra,
+		renderer:    renderer,
+		connections: connections,
+		hydra:       hydra,
+		sessions:    sessions,
+		config:      config,
 	}
 }
 
@@ -43,8 +89,25 @@ func (s *Service) Router() http.Handler {
 
 	r.GET("/authentication/login", csrf.Protect(s.beginLogin))
 	r.POST("/authentication/login", csrf.Protect(s.completeLogin))
+	r.POST("/authentication/login/identify", csrf.Protect(s.identifyLogin))
+	r.POST("/authentication/login/federate", csrf.Protect(s.federateLogin))
+	r.GET(callbackPath, csrf.Protect(s.federationCallback))
+	r.GET(samlMetadataPath, s.samlMetadata)
+	r.POST(samlACSPath, csrf.Exempt(s.samlACS))
 	r.GET("/authentication/consent", csrf.Protect(s.beginConsent))
 	r.POST("/authentication/consent", csrf.Protect(s.completeConsent))
+	r.GET("/authentication/logout", csrf.Protect(s.beginLogout))
+	r.POST("/authentication/logout", csrf.Protect(s.completeLogout))
+	r.GET("/api/v1/login", csrf.Protect(s.apiBeginLogin))
+	r.POST("/api/v1/login", csrf.Protect(s.apiCompleteLogin))
+	r.POST("/api/v1/login/identify", csrf.Protect(s.apiIdentifyLogin))
+	r.POST("/api/v1/login/federate", csrf.Protect(s.apiFederateLogin))
+	r.GET("/api/v1/consent", csrf.Protect(s.apiBeginConsent))
+	r.POST("/api/v1/consent", csrf.Protect(s.apiCompleteConsent))
+	r.GET("/account/apps", csrf.Protect(s.sessions.Protect(s.listApps, s.unauthenticated)))
+	r.POST("/account/apps/revoke", csrf.Protect(s.sessions.Protect(s.revokeApp, s.unauthenticated)))
+	r.GET("/account/sessions", csrf.Protect(s.sessions.Protect(s.showSessions, s.unauthenticated)))
+	r.POST("/account/sessions/revoke", csrf.Protect(s.sessions.Protect(s.revokeSessions, s.unauthenticated)))
 
 	return r
 }
@@ -59,49 +122,70 @@ func (s *Service) beginLogin(w http.ResponseWriter, r *http.Request, _ httproute
 		return
 	}
 
-	params := hydraAdmin.NewGetLoginRequestParams()
-	params.WithContext(r.Context())
-	params.SetLoginChallenge(challenge)
-
-	req, err := s.hydra.GetLoginRequest(params)
+	req, err := s.getLoginRequest(r.Context(), challenge)
 	if err != nil {
-		_ = s.renderer.Render(w, http.StatusOK, "login", csrf.WithToken(r, map[string]interface{}{
-			"ErrorMessage": "Failed to initiate login request",
-		}))
+		s.challengeFailed(w, r, err, "Failed to initiate login request")
+		return
+	}
 
+	if redirectTo, done, err := s.skipLogin(r, req); done {
+		s.complete(w, r, redirectTo, err, "Failed to complete login request")
 		return
 	}
 
-	var skip bool
-	if req.GetPayload().Skip != nil {
-		skip = *req.GetPayload().Skip
+	s.renderLogin(w, r, http.StatusOK, req, map[string]interface{}{})
+}
+
+// identifyLogin discovers the connection from the email the user
+// entered and either shows its form or sends the user to it if it
+// is federated. If there is none, the email is asked for again.
+func (s *Service) identifyLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
 	}
 
-	if skip {
-		params := hydraAdmin.NewAcceptLoginRequestParams()
-		params.WithContext(r.Context())
-		params.SetLoginChallenge(challenge)
-		params.SetBody(&models.AcceptLoginRequest{
-			Subject: req.GetPayload().Subject,
-		})
+	var (
+		loginChallenge = strings.TrimSpace(r.PostFormValue(loginChallengeKey))
+		email          = strings.TrimSpace(r.PostFormValue("email"))
+	)
+
+	if loginChallenge == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 
-		reqAccept, err := s.hydra.AcceptLoginRequest(params)
-		if err != nil {
-			_ = s.renderer.Render(w, http.StatusOK, "login", csrf.WithToken(r, map[string]interface{}{
-				"ErrorMessage": "The resource owner denied the request",
-			}))
+	req, err := s.getLoginRequest(r.Context(), loginChallenge)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get login request info")
+		return
+	}
+
+	c, ok := discoverConnection(s.allowedConnections(req.Client), email)
+	if !ok {
+		_, messages := template.Localize(loginParams(req).uiLocales)
 
-			return
+		message := messages.UnknownDomain
+		if email == "" {
+			message = messages.EnterEmail
 		}
 
-		http.Redirect(w, r, *reqAccept.GetPayload().RedirectTo, http.StatusFound)
+		s.renderLogin(w, r, http.StatusBadRequest, req, map[string]interface{}{
+			"Email":        email,
+			"ErrorMessage": message,
+		})
+
+		return
+	}
 
+	if _, ok := c.Provider.(provider.Federated); ok {
+		s.federate(w, r, req, c.Name)
 		return
 	}
 
-	_ = s.renderer.Render(w, http.StatusOK, "login", csrf.WithToken(r, map[string]interface{}{
-		"LoginChallenge": challenge,
-	}))
+	s.renderLogin(w, r, http.StatusOK, req, map[string]interface{}{
+		"Email": email,
+	})
 }
 
 func (s *Service) completeLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -113,8 +197,9 @@ func (s *Service) completeLogin(w http.ResponseWriter, r *http.Request, p httpro
 	var (
 		loginChallenge = strings.TrimSpace(r.PostFormValue(loginChallengeKey))
 		email          = strings.TrimSpace(r.PostFormValue("email"))
-		password       = strings.TrimSpace(r.PostFormValue("password"))
+		password       = r.PostFormValue("password")
 		rememberMe     = strings.TrimSpace(r.PostFormValue("remember_me"))
+		connection     = strings.TrimSpace(r.PostFormValue(connectionKey))
 	)
 
 	if loginChallenge == "" {
@@ -122,40 +207,44 @@ func (s *Service) completeLogin(w http.ResponseWriter, r *http.Request, p httpro
 		return
 	}
 
-	params := hydraAdmin.NewGetLoginRequestParams()
-	params.SetLoginChallenge(loginChallenge)
-
-	if _, err := s.hydra.GetLoginRequest(params); err != nil {
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+	req, err := s.getLoginRequest(r.Context(), loginChallenge)
+	if err != nil {
+		s.challengeFailed(w, r, err, "Failed to get login request info")
 		return
 	}
 
-	i, err := s.identity.Provide(r.Context(), provider.Credentials{
+	conn, i, err := s.provide(r.Context(), req, connection, provider.Credentials{
 		"email":    email,
 		"password": password,
 	})
+	if err == nil {
+		err = requireLevel(req, i)
+	}
 
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		s.loginFailed(w, r, req, email, err)
 		return
 	}
 
-	acceptParams := hydraAdmin.NewAcceptLoginRequestParams()
-	acceptParams.WithContext(r.Context())
-	acceptParams.SetLoginChallenge(loginChallenge)
-	acceptParams.SetBody(&models.AcceptLoginRequest{
-		Subject:     &i,
-		Remember:    rememberMe == "true",
-		RememberFor: rememberFor,
-	})
+	redirectTo, err := s.authenticated(w, r, req, conn.Name, i, rememberMe == "true")
+	s.complete(w, r, redirectTo, err, "Failed to complete login request")
+}
 
-	reqAccept, err := s.hydra.AcceptLoginRequest(acceptParams)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+// loginFailed re-renders the login form for failures the user can
+// correct, and rejects the login request for the ones they can not.
+func (s *Service) loginFailed(w http.ResponseWriter, r *http.Request, req *models.LoginRequest, email string, err error) {
+	_, messages := template.Localize(loginParams(req).uiLocales)
+	f := classifyLoginError(err, messages)
+
+	if f.reject != nil {
+		s.rejectLogin(w, r, *req.Challenge, *f.reject)
 		return
 	}
 
-	http.Redirect(w, r, *reqAccept.GetPayload().RedirectTo, http.StatusFound)
+	s.renderLogin(w, r, f.status, req, map[string]interface{}{
+		"Email":        email,
+		"ErrorMessage": f.message,
+	})
 }
 
 func (s *Service) beginConsent(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -168,47 +257,26 @@ func (s *Service) beginConsent(w http.ResponseWriter, r *http.Request, p httprou
 		return
 	}
 
-	params := hydraAdmin.NewGetConsentRequestParams()
-	params.WithContext(r.Context())
-	params.SetConsentChallenge(challenge)
-
-	req, err := s.hydra.GetConsentRequest(params)
+	req, err := s.getConsentRequest(r.Context(), challenge)
 	if err != nil {
-		_ = s.renderer.Render(w, http.StatusOK, "consent", csrf.WithToken(r, map[string]interface{}{
-			"ErrorMessage": "Failed to get consent request info",
-		}))
-
+		s.challengeFailed(w, r, err, "Failed to get consent request info")
 		return
 	}
 
-	if req.GetPayload().Skip {
-		params := hydraAdmin.NewAcceptConsentRequestParams()
-		params.WithContext(r.Context())
-		params.SetConsentChallenge(challenge)
-		params.WithBody(&models.AcceptConsentRequest{
-			GrantAccessTokenAudience: req.GetPayload().RequestedAccessTokenAudience,
-			GrantScope:               req.GetPayload().RequestedScope,
-		})
-
-		reqAccept, err := s.hydra.AcceptConsentRequest(params)
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
-			return
-		}
-
-		http.Redirect(w, r, *reqAccept.GetPayload().RedirectTo, http.StatusFound)
-
+	if redirectTo, done, err := s.skipConsent(r.Context(), req); done {
+		s.complete(w, r, redirectTo, err, "Failed to complete consent request")
 		return
 	}
 
 	consentMessage := fmt.Sprintf("Application %s wants access resources on your behalf and to:",
-		req.GetPayload().Client.ClientName,
+		req.Client.ClientName,
 	)
 
 	_ = s.renderer.Render(w, http.StatusOK, "consent", csrf.WithToken(r, map[string]interface{}{
-		"ConsentChallenge": challenge,
-		"ConsentMessage":   consentMessage,
-		"RequestedScopes":  req.GetPayload().RequestedScope,
+		"ConsentChallenge":   challenge,
+		"ConsentMessage":     consentMessage,
+		"ScopeGroups":        s.consentScopeGroups(req.RequestedScope),
+		"RequestedAudiences": s.consentAudiences(req.RequestedAccessTokenAudience),
 	}))
 }
 
@@ -220,7 +288,12 @@ func (s *Service) completeConsent(w http.ResponseWriter, r *http.Request, p http
 
 	var (
 		consentChallenge = strings.TrimSpace(r.PostFormValue(consentChallengeKey))
-		grantScope       = r.Form[grantScopeKey]
+		decision         = consentDecision{
+			deny:     strings.TrimSpace(r.PostFormValue(actionKey)) == actionDeny,
+			remember: strings.TrimSpace(r.PostFormValue(rememberKey)) == "true",
+			scope:    r.Form[grantScopeKey],
+			audience: r.Form[grantAudienceKey],
+		}
 	)
 
 	if consentChallenge == "" {
@@ -228,29 +301,12 @@ func (s *Service) completeConsent(w http.ResponseWriter, r *http.Request, p http
 		return
 	}
 
-	params := hydraAdmin.NewGetConsentRequestParams()
-	params.WithContext(r.Context())
-	params.SetConsentChallenge(consentChallenge)
-
-	req, err := s.hydra.GetConsentRequest(params)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
-		return
-	}
-
-	acceptParams := hydraAdmin.NewAcceptConsentRequestParams()
-	acceptParams.WithContext(r.Context())
-	acceptParams.SetConsentChallenge(consentChallenge)
-	acceptParams.WithBody(&models.AcceptConsentRequest{
-		GrantAccessTokenAudience: req.GetPayload().RequestedAccessTokenAudience,
-		GrantScope:               grantScope,
-	})
-
-	reqAccept, err := s.hydra.AcceptConsentRequest(acceptParams)
+	req, err := s.getConsentRequest(r.Context(), consentChallenge)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		s.challengeFailed(w, r, err, "Failed to get consent request info")
 		return
 	}
 
-	http.Redirect(w, r, *reqAccept.GetPayload().RedirectTo, http.StatusFound)
+	redirectTo, err := s.decideConsent(r.Context(), req, &decision)
+	s.complete(w, r, redirectTo, err, "Failed to complete consent request")
 }
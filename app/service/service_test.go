@@ -0,0 +1,1843 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpraski/identity-provider/app/claims"
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+	"github.com/mpraski/identity-provider/app/gateway/oidctest"
+	"github.com/mpraski/identity-provider/app/hydratest"
+	"github.com/mpraski/identity-provider/app/provider"
+	"github.com/mpraski/identity-provider/app/session"
+	"github.com/mpraski/identity-provider/app/template"
+	"github.com/ory/hydra-client-go/models"
+)
+
+type (
+	// testService serves the service in front of a fake Hydra,
+	// with a client that keeps cookies and does not follow redirects.
+	testService struct {
+		*httptest.Server
+		service *Service
+		hydra   *hydratest.Admin
+		client  *http.Client
+	}
+
+	// stubProvider authenticates anyone with the password "secret",
+	// and fails in a different way for each of the other passwords.
+	stubProvider struct{}
+)
+
+const (
+	testSubject = "user-1"
+	testClient  = "app"
+	hydraURL    = "https://hydra.test"
+)
+
+var (
+	errBroken = errors.New("identity service is broken")
+
+	tokenPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+)
+
+func (stubProvider) Provide(_ context.Context, creds provider.Credentials) (*provider.AuthenticatedIdentity, error) {
+	switch creds["password"] {
+	case "secret":
+		methods := []provider.Method{provider.MethodPassword}
+
+		return &provider.AuthenticatedIdentity{
+			Subject:  testSubject,
+			Traits:   &provider.Traits{Email: creds["email"], EmailVerified: true},
+			Methods:  methods,
+			Level:    provider.LevelOf(methods),
+			AuthTime: time.Now(),
+		}, nil
+	case "":
+		return nil, provider.ErrPasswordMissing
+	case "disabled":
+		return nil, provider.ErrAccountDisabled
+	case "locked":
+		return nil, provider.ErrAccountLocked
+	case "broken":
+		return nil, errBroken
+	default:
+		return nil, provider.ErrInvalidCredentials
+	}
+}
+
+// Traits looks up the traits of the only subject there is.
+func (stubProvider) Traits(_ context.Context, subject provider.Subject) (*provider.Traits, error) {
+	if subject != testSubject {
+		return nil, errBroken
+	}
+
+	return &provider.Traits{Email: "jdoe@example.com", EmailVerified: true}, nil
+}
+
+// newTestService registers the connections after the password one.
+func newTestService(t *testing.T, config Config, extra ...provider.Connection) *testService {
+	t.Helper()
+
+	connections := provider.NewRegistry()
+
+	for _, c := range append([]provider.Connection{{Name: "password", Provider: stubProvider{}}}, extra...) {
+		if err := connections.Register(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := session.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(nil)
+
+	// Federated connections send users back to the test server.
+	if config.CallbackURL == "" {
+		config.CallbackURL = "http://" + server.Listener.Addr().String() + callbackPath
+	}
+
+	var (
+		hydra    = hydratest.New()
+		renderer = template.NewRenderer(os.DirFS("../.."))
+		sessions = session.NewStore(key, time.Hour, false)
+		s        = New(renderer, connections, hydra, sessions, config)
+	)
+
+	server.Config.Handler = s.Router()
+	server.Start()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testService{
+		Server:  server,
+		service: s,
+		hydra:   hydra,
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func (ts *testService) get(t *testing.T, path string) (*http.Response, string) {
+	t.Helper()
+
+	return ts.visit(t, ts.URL+path)
+}
+
+// visit gets the URL, which may point elsewhere than the service.
+func (ts *testService) visit(t *testing.T, rawURL string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := ts.client.Get(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, readBody(t, resp)
+}
+
+// token returns a CSRF token, which it gets from the logout
+// page, as that works without any prior state.
+func (ts *testService) token(t *testing.T) string {
+	t.Helper()
+
+	challenge := ts.hydra.NewLogoutRequest(&models.LogoutRequest{Subject: testSubject})
+
+	_, body := ts.get(t, "/authentication/logout?logout_challenge="+challenge)
+
+	m := tokenPattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("no CSRF token on the logout page")
+	}
+
+	return html.UnescapeString(m[1])
+}
+
+// post submits the form along with a CSRF token.
+func (ts *testService) post(t *testing.T, path string, form url.Values) (*http.Response, string) {
+	t.Helper()
+
+	form.Set("csrf_token", ts.token(t))
+
+	resp, err := ts.client.PostForm(ts.URL+path, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, readBody(t, resp)
+}
+
+// postJSON sends the body as JSON, with a CSRF token in the header
+// as the front end does. A string body is sent as it is.
+func (ts *testService) postJSON(t *testing.T, path string, body interface{}) (*http.Response, string) {
+	t.Helper()
+
+	raw, ok := body.(string)
+	if !ok {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw = string(b)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", ts.token(t))
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, readBody(t, resp)
+}
+
+// signIn signs the test subject in with a password, which gives
+// the browser a session of its own, and has Hydra remember the login.
+func (ts *testService) signIn(t *testing.T) {
+	t.Helper()
+
+	challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}})
+
+	resp, body := ts.post(t, "/authentication/login", url.Values{
+		loginChallengeKey: {challenge},
+		connectionKey:     {"password"},
+		"email":           {"jdoe@example.com"},
+		"password":        {"secret"},
+		"remember_me":     {"true"},
+	})
+	checkResponse(t, resp, body, http.StatusFound, hydraURL+"/oauth2/auth?login_verifier=", "")
+}
+
+// federatedConnection returns an OpenID Connect connection
+// to a stub issuer, routing the domains to it.
+func federatedConnection(t *testing.T, name string, domains ...string) (provider.Connection, *oidctest.Issuer) {
+	t.Helper()
+
+	issuer := oidctest.NewIssuer("identity-provider", "client-secret")
+	t.Cleanup(issuer.Close)
+
+	issuer.SetClaims(map[string]interface{}{"sub": "jdoe", "email": "jdoe@example.com"})
+
+	client, err := oidc.New(issuer.URL, "identity-provider", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider.Connection{
+		Name:     name,
+		Domains:  domains,
+		Provider: provider.NewOIDCProvider(client, name+":"),
+	}, issuer
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// checkOutcome checks that the request led to the given operation on the
+// challenge, and for rejections, that it was rejected with the given error.
+// No operation means the challenge must have been left alone.
+func checkOutcome(t *testing.T, hydra *hydratest.Admin, challenge, operation, rejectError string) hydratest.Call {
+	t.Helper()
+
+	var handled hydratest.Call
+
+	for _, c := range hydra.Calls() {
+		if c.Challenge != challenge || strings.HasPrefix(c.Operation, "Get") {
+			continue
+		}
+
+		if handled.Operation != "" {
+			t.Fatalf("challenge handled twice, by %s and %s", handled.Operation, c.Operation)
+		}
+
+		handled = c
+	}
+
+	if handled.Operation != operation {
+		t.Fatalf("challenge handled by %q, want %q", handled.Operation, operation)
+	}
+
+	if rejectError != "" {
+		body, ok := handled.Body.(*models.RejectRequest)
+		if !ok || body.Error != rejectError {
+			t.Fatalf("rejected with %+v, want error %s", handled.Body, rejectError)
+		}
+	}
+
+	return handled
+}
+
+func checkResponse(t *testing.T, resp *http.Response, body string, status int, location, contains string) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("status = %d, want %d, body: %s", resp.StatusCode, status, body)
+	}
+
+	if got := resp.Header.Get("Location"); !strings.HasPrefix(got, location) || (location == "") != (got == "") {
+		t.Fatalf("location = %q, want it to start with %q", got, location)
+	}
+
+	if !strings.Contains(body, contains) {
+		t.Fatalf("body does not contain %q: %s", contains, body)
+	}
+}
+
+func TestBeginLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		request    *models.LoginRequest
+		challenge  string
+		remember   string
+		status     int
+		location   string
+		contains   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:     "challenge missing",
+			status:   http.StatusBadRequest,
+			contains: "Expected a login challenge",
+		},
+		{
+			name:      "challenge unknown",
+			challenge: "login-challenge-unknown",
+			status:    http.StatusNotFound,
+			contains:  "expired or does not exist",
+		},
+		{
+			name:     "form shown",
+			request:  &models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}},
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name:     "remembered login without a session shown",
+			request:  &models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}},
+			remember: testSubject,
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name: "no prompt rejected",
+			request: &models.LoginRequest{
+				Client:     &models.OAuth2Client{ClientID: testClient},
+				RequestURL: stringPtr(hydraURL + "/oauth2/auth?prompt=none"),
+			},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "login_required",
+		},
+		{
+			name: "no connection rejected",
+			request: &models.LoginRequest{Client: &models.OAuth2Client{
+				ClientID: testClient,
+				Metadata: map[string]interface{}{metadataConnections: []interface{}{"unknown"}},
+			}},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "access_denied",
+		},
+		{
+			name: "malformed connections rejected",
+			request: &models.LoginRequest{Client: &models.OAuth2Client{
+				ClientID: testClient,
+				Metadata: map[string]interface{}{metadataConnections: "password"},
+			}},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "access_denied",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			if c.remember != "" {
+				ts.hydra.RememberLogin(c.remember)
+			}
+
+			challenge := c.challenge
+			if c.request != nil {
+				challenge = ts.hydra.NewLoginRequest(c.request)
+			}
+
+			path := "/authentication/login"
+			if challenge != "" {
+				path += "?login_challenge=" + challenge
+			}
+
+			resp, body := ts.get(t, path)
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+		})
+	}
+}
+
+func TestCompleteLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		acrValues  []string
+		password   string
+		status     int
+		location   string
+		contains   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:      "accepted",
+			password:  "secret",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "invalid credentials shown",
+			password: "wrong",
+			status:   http.StatusUnauthorized,
+			contains: "Invalid email or password",
+		},
+		{
+			name:     "locked account shown as invalid credentials",
+			password: "locked",
+			status:   http.StatusUnauthorized,
+			contains: "Invalid email or password",
+		},
+		{
+			name:     "missing password shown",
+			status:   http.StatusBadRequest,
+			contains: "Please enter your email address and password",
+		},
+		{
+			name:       "disabled account rejected",
+			password:   "disabled",
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "access_denied",
+		},
+		{
+			name:     "provider error shown",
+			password: "broken",
+			status:   http.StatusInternalServerError,
+			contains: "Failed to sign in",
+		},
+		{
+			name:      "step-up asked for",
+			acrValues: []string{provider.LevelMultiFactor.ACR()},
+			password:  "secret",
+			status:    http.StatusForbidden,
+			contains:  "requires a stronger way of signing in",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{
+				Client:      &models.OAuth2Client{ClientID: testClient},
+				OidcContext: &models.OpenIDConnectContext{AcrValues: c.acrValues},
+			})
+
+			resp, body := ts.post(t, "/authentication/login", url.Values{
+				loginChallengeKey: {challenge},
+				"email":           {"jdoe@example.com"},
+				"password":        {c.password},
+				"remember_me":     {"true"},
+			})
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			call := checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+
+			if c.operation != hydratest.OpAcceptLoginRequest {
+				return
+			}
+
+			accept, _ := call.Body.(*models.AcceptLoginRequest)
+			if accept == nil || *accept.Subject != testSubject || !accept.Remember || accept.Acr != provider.LevelSingleFactor.ACR() {
+				t.Fatalf("accepted with %+v", call.Body)
+			}
+
+			if _, ok := contextTraits(accept.Context); !ok {
+				t.Fatal("traits are not carried over to the consent request")
+			}
+		})
+	}
+
+	t.Run("challenge unknown", func(t *testing.T) {
+		ts := newTestService(t, Config{})
+
+		resp, body := ts.post(t, "/authentication/login", url.Values{
+			loginChallengeKey: {"login-challenge-unknown"},
+			"password":        {"secret"},
+		})
+
+		checkResponse(t, resp, body, http.StatusNotFound, "", "expired or does not exist")
+	})
+}
+
+// TestRememberedLoginTraits checks that the traits of a subject whose
+// login is skipped are looked up with the connection they signed in with.
+func TestRememberedLoginTraits(t *testing.T) {
+	ts := newTestService(t, Config{})
+	client := &models.OAuth2Client{ClientID: testClient}
+
+	challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: client})
+
+	resp, body := ts.post(t, "/authentication/login", url.Values{
+		loginChallengeKey: {challenge},
+		"email":           {"jdoe@example.com"},
+		"password":        {"secret"},
+		"remember_me":     {"true"},
+	})
+	checkResponse(t, resp, body, http.StatusFound, hydraURL+"/oauth2/auth?login_verifier=", "")
+
+	challenge = ts.hydra.NewLoginRequest(&models.LoginRequest{Client: client})
+
+	resp, body = ts.get(t, "/authentication/login?login_challenge="+challenge)
+	checkResponse(t, resp, body, http.StatusFound, hydraURL+"/oauth2/auth?login_verifier=", "")
+
+	call := checkOutcome(t, ts.hydra, challenge, hydratest.OpAcceptLoginRequest, "")
+	accept, _ := call.Body.(*models.AcceptLoginRequest)
+
+	if connection := contextConnection(accept.Context); connection != "password" {
+		t.Fatalf("connection = %q, want password", connection)
+	}
+
+	challenge = ts.hydra.NewConsentRequest(&models.ConsentRequest{
+		Subject:        testSubject,
+		Client:         client,
+		RequestedScope: []string{"openid", "email"},
+		Context:        accept.Context,
+	})
+
+	resp, body = ts.post(t, "/authentication/consent", url.Values{
+		consentChallengeKey: {challenge},
+		actionKey:           {actionAccept},
+		grantScopeKey:       {"openid", "email"},
+	})
+	checkResponse(t, resp, body, http.StatusFound, hydraURL+"/oauth2/auth?consent_verifier=", "")
+
+	call = checkOutcome(t, ts.hydra, challenge, hydratest.OpAcceptConsentRequest, "")
+	consent, _ := call.Body.(*models.AcceptConsentRequest)
+
+	if idToken, _ := consent.Session.IDToken.(claims.Claims); idToken["email"] != "jdoe@example.com" {
+		t.Fatalf("ID token claims = %+v", consent.Session.IDToken)
+	}
+}
+
+// TestRememberedLogin checks that a remembered login is only accepted
+// for clients which allow the connection the subject signed in with.
+func TestRememberedLogin(t *testing.T) {
+	cases := []struct {
+		name      string
+		allowed   []interface{}
+		prompt    string
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:      "any connection allowed",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:      "connection allowed",
+			allowed:   []interface{}{"other", "password"},
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "connection not allowed shown",
+			allowed:  []interface{}{"other"},
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name:     "login prompted shown",
+			prompt:   promptLogin,
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{}, provider.Connection{Name: "other", Provider: stubProvider{}})
+			ts.signIn(t)
+
+			client := &models.OAuth2Client{ClientID: "other-app"}
+			if c.allowed != nil {
+				client.Metadata = map[string]interface{}{metadataConnections: c.allowed}
+			}
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{
+				Client:     client,
+				RequestURL: stringPtr(hydraURL + "/oauth2/auth?prompt=" + c.prompt),
+			})
+
+			resp, body := ts.get(t, "/authentication/login?login_challenge="+challenge)
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+		})
+	}
+}
+
+// TestRememberedLoginMaxAge checks that a remembered login is only
+// accepted if the subject is known to have authenticated recently enough.
+func TestRememberedLoginMaxAge(t *testing.T) {
+	cases := []struct {
+		name      string
+		authTime  time.Time
+		maxAge    string
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:      "recent enough",
+			authTime:  time.Now().Add(-time.Minute),
+			maxAge:    "3600",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "too long ago shown",
+			authTime: time.Now().Add(-2 * time.Hour),
+			maxAge:   "3600",
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name:     "authentication time unknown shown",
+			maxAge:   "3600",
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name:      "authentication time unknown without maximum age",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+			ts.signIn(t)
+
+			// The session is replaced by one authenticated at the time of the case.
+			w := httptest.NewRecorder()
+			ts.service.sessions.Set(w, &session.Session{Subject: testSubject, Connection: "password", AuthTime: c.authTime})
+
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ts.client.Jar.SetCookies(u, w.Result().Cookies())
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{
+				Client:     &models.OAuth2Client{ClientID: testClient},
+				RequestURL: stringPtr(hydraURL + "/oauth2/auth?max_age=" + c.maxAge),
+			})
+
+			resp, body := ts.get(t, "/authentication/login?login_challenge="+challenge)
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+		})
+	}
+}
+
+func TestIdentifyLogin(t *testing.T) {
+	cases := []struct {
+		name      string
+		challenge string
+		email     string
+		handoff   bool
+		status    int
+		contains  string
+	}{
+		{
+			name:     "connection of the domain shown",
+			email:    "jdoe@corp.test",
+			status:   http.StatusOK,
+			contains: `name="password"`,
+		},
+		{
+			name:    "federated connection handed off to",
+			email:   "jdoe@partner.test",
+			handoff: true,
+			status:  http.StatusFound,
+		},
+		{
+			name:     "unknown domain shown",
+			email:    "jdoe@example.com",
+			status:   http.StatusBadRequest,
+			contains: "Signing in with this email address is not supported",
+		},
+		{
+			name:     "missing email shown",
+			status:   http.StatusBadRequest,
+			contains: "Please enter your email address",
+		},
+		{
+			name:      "challenge unknown",
+			challenge: "login-challenge-unknown",
+			email:     "jdoe@corp.test",
+			status:    http.StatusNotFound,
+			contains:  "expired or does not exist",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			partner, issuer := federatedConnection(t, "partner", "partner.test")
+
+			ts := newTestService(t, Config{},
+				provider.Connection{Name: "corp", Domains: []string{"corp.test"}, Provider: stubProvider{}},
+				partner,
+			)
+
+			// The password connection routes no domain, so it would
+			// be the fallback for any other one if it were allowed.
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{
+				ClientID: testClient,
+				Metadata: map[string]interface{}{metadataConnections: []interface{}{"corp", "partner"}},
+			}})
+			if c.challenge != "" {
+				challenge = c.challenge
+			}
+
+			resp, body := ts.post(t, "/authentication/login/identify", url.Values{
+				loginChallengeKey: {challenge},
+				"email":           {c.email},
+			})
+
+			location := ""
+			if c.handoff {
+				location = issuer.URL + "/authorize?"
+			}
+
+			checkResponse(t, resp, body, c.status, location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, "", "")
+		})
+	}
+}
+
+func TestFederateLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		allowed    []interface{}
+		connection string
+		challenge  string
+		handoff    bool
+		status     int
+		contains   string
+	}{
+		{
+			name:       "handed off",
+			connection: "partner",
+			handoff:    true,
+			status:     http.StatusFound,
+		},
+		{
+			name:       "only allowed connection handed off to",
+			allowed:    []interface{}{"partner"},
+			connection: "",
+			handoff:    true,
+			status:     http.StatusFound,
+		},
+		{
+			name:       "connection not federated shown",
+			connection: "password",
+			status:     http.StatusBadRequest,
+			contains:   "Please choose how to sign in",
+		},
+		{
+			name:       "connection unknown shown",
+			connection: "unknown",
+			status:     http.StatusBadRequest,
+			contains:   "Please choose how to sign in",
+		},
+		{
+			name:       "connection not allowed shown",
+			allowed:    []interface{}{"password"},
+			connection: "partner",
+			status:     http.StatusBadRequest,
+			contains:   "Please choose how to sign in",
+		},
+		{
+			name:       "challenge unknown",
+			connection: "partner",
+			challenge:  "login-challenge-unknown",
+			status:     http.StatusNotFound,
+			contains:   "expired or does not exist",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			partner, issuer := federatedConnection(t, "partner")
+			ts := newTestService(t, Config{}, partner)
+
+			client := &models.OAuth2Client{ClientID: testClient}
+			if c.allowed != nil {
+				client.Metadata = map[string]interface{}{metadataConnections: c.allowed}
+			}
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: client})
+			if c.challenge != "" {
+				challenge = c.challenge
+			}
+
+			resp, body := ts.post(t, "/authentication/login/federate", url.Values{
+				loginChallengeKey: {challenge},
+				connectionKey:     {c.connection},
+			})
+
+			location := ""
+			if c.handoff {
+				location = issuer.URL + "/authorize?"
+			}
+
+			checkResponse(t, resp, body, c.status, location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, "", "")
+		})
+	}
+}
+
+func TestBeginConsent(t *testing.T) {
+	cases := []struct {
+		name      string
+		client    string
+		remember  bool
+		challenge string
+		noRequest bool
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:      "challenge missing",
+			noRequest: true,
+			status:    http.StatusBadRequest,
+			contains:  "Expected a consent challenge",
+		},
+		{
+			name:      "challenge unknown",
+			noRequest: true,
+			challenge: "consent-challenge-unknown",
+			status:    http.StatusNotFound,
+			contains:  "expired or does not exist",
+		},
+		{
+			name:     "form shown",
+			client:   testClient,
+			status:   http.StatusOK,
+			contains: `name="grant_scope"`,
+		},
+		{
+			name:      "remembered consent accepted",
+			client:    testClient,
+			remember:  true,
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?consent_verifier=",
+			operation: hydratest.OpAcceptConsentRequest,
+		},
+		{
+			name:      "trusted client accepted",
+			client:    "first-party",
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?consent_verifier=",
+			operation: hydratest.OpAcceptConsentRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{TrustedClients: []string{"first-party"}})
+
+			client := &models.OAuth2Client{ClientID: c.client}
+			if c.remember {
+				ts.hydra.RememberConsent(testSubject, client, "openid", "email")
+			}
+
+			challenge := c.challenge
+			if !c.noRequest {
+				challenge = ts.hydra.NewConsentRequest(newConsentRequest(client))
+			}
+
+			path := "/authentication/consent"
+			if challenge != "" {
+				path += "?consent_challenge=" + challenge
+			}
+
+			resp, body := ts.get(t, path)
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+		})
+	}
+}
+
+func TestCompleteConsent(t *testing.T) {
+	cases := []struct {
+		name       string
+		form       url.Values
+		status     int
+		location   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:      "accepted",
+			form:      url.Values{actionKey: {actionAccept}, grantScopeKey: {"openid", "email"}},
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?consent_verifier=",
+			operation: hydratest.OpAcceptConsentRequest,
+		},
+		{
+			name:       "denied",
+			form:       url.Values{actionKey: {actionDeny}},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectConsentRequest,
+			rejectWith: "access_denied",
+		},
+		{
+			name:       "unrequested scope rejected",
+			form:       url.Values{actionKey: {actionAccept}, grantScopeKey: {"openid", "phone"}},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectConsentRequest,
+			rejectWith: "invalid_scope",
+		},
+		{
+			name:       "unrequested audience rejected",
+			form:       url.Values{actionKey: {actionAccept}, grantAudienceKey: {"https://api.invalid"}},
+			status:     http.StatusFound,
+			location:   hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectConsentRequest,
+			rejectWith: "invalid_request",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := ts.hydra.NewConsentRequest(newConsentRequest(&models.OAuth2Client{ClientID: testClient}))
+			c.form.Set(consentChallengeKey, challenge)
+
+			resp, body := ts.post(t, "/authentication/consent", c.form)
+
+			checkResponse(t, resp, body, c.status, c.location, "")
+			call := checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+
+			if c.operation != hydratest.OpAcceptConsentRequest {
+				return
+			}
+
+			accept, _ := call.Body.(*models.AcceptConsentRequest)
+			if accept == nil || len(accept.GrantScope) != 2 {
+				t.Fatalf("accepted with %+v", call.Body)
+			}
+
+			if idToken, _ := accept.Session.IDToken.(claims.Claims); idToken["email"] != "jdoe@example.com" {
+				t.Fatalf("ID token claims = %+v", accept.Session.IDToken)
+			}
+		})
+	}
+
+	t.Run("challenge unknown", func(t *testing.T) {
+		ts := newTestService(t, Config{})
+
+		resp, body := ts.post(t, "/authentication/consent", url.Values{
+			consentChallengeKey: {"consent-challenge-unknown"},
+			actionKey:           {actionAccept},
+		})
+
+		checkResponse(t, resp, body, http.StatusNotFound, "", "expired or does not exist")
+	})
+}
+
+func TestBeginLogout(t *testing.T) {
+	cases := []struct {
+		name      string
+		request   *models.LogoutRequest
+		challenge string
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:     "challenge missing",
+			status:   http.StatusBadRequest,
+			contains: "Expected a logout challenge",
+		},
+		{
+			name:      "challenge unknown",
+			challenge: "logout-challenge-unknown",
+			status:    http.StatusNotFound,
+			contains:  "expired or does not exist",
+		},
+		{
+			name:     "confirmation shown",
+			request:  &models.LogoutRequest{Subject: testSubject},
+			status:   http.StatusOK,
+			contains: `name="logout_challenge"`,
+		},
+		{
+			name:      "relying party logout accepted",
+			request:   &models.LogoutRequest{Subject: testSubject, RpInitiated: true},
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?logout_verifier=",
+			operation: hydratest.OpAcceptLogoutRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := c.challenge
+			if c.request != nil {
+				challenge = ts.hydra.NewLogoutRequest(c.request)
+			}
+
+			path := "/authentication/logout"
+			if challenge != "" {
+				path += "?logout_challenge=" + challenge
+			}
+
+			resp, body := ts.get(t, path)
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+		})
+	}
+}
+
+func TestCompleteLogout(t *testing.T) {
+	cases := []struct {
+		name      string
+		client    *models.OAuth2Client
+		action    string
+		status    int
+		location  string
+		contains  string
+		operation string
+	}{
+		{
+			name:      "accepted",
+			action:    actionAccept,
+			status:    http.StatusFound,
+			location:  hydraURL + "/oauth2/auth?logout_verifier=",
+			operation: hydratest.OpAcceptLogoutRequest,
+		},
+		{
+			name:      "rejected back to the client",
+			client:    &models.OAuth2Client{ClientID: testClient, ClientURI: "https://app.test"},
+			action:    actionDeny,
+			status:    http.StatusFound,
+			location:  "https://app.test",
+			operation: hydratest.OpRejectLogoutRequest,
+		},
+		{
+			name:      "rejected without a client",
+			action:    actionDeny,
+			status:    http.StatusOK,
+			contains:  "You are still signed in",
+			operation: hydratest.OpRejectLogoutRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+			ts.hydra.RememberLogin(testSubject)
+
+			challenge := ts.hydra.NewLogoutRequest(&models.LogoutRequest{Subject: testSubject, Client: c.client})
+
+			resp, body := ts.post(t, "/authentication/logout", url.Values{
+				logoutChallengeKey: {challenge},
+				actionKey:          {c.action},
+			})
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+
+			_, remembered := ts.hydra.RememberedLogin()
+			if remembered != (c.operation != hydratest.OpAcceptLogoutRequest) {
+				t.Fatalf("login remembered = %t after the logout was handled by %s", remembered, c.operation)
+			}
+		})
+	}
+
+	t.Run("challenge unknown", func(t *testing.T) {
+		ts := newTestService(t, Config{})
+
+		resp, body := ts.post(t, "/authentication/logout", url.Values{
+			logoutChallengeKey: {"logout-challenge-unknown"},
+			actionKey:          {actionAccept},
+		})
+
+		checkResponse(t, resp, body, http.StatusNotFound, "", "expired or does not exist")
+	})
+}
+
+func TestAPIBeginLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		request    *models.LoginRequest
+		challenge  string
+		status     int
+		contains   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:     "challenge missing",
+			status:   http.StatusBadRequest,
+			contains: `"error":"invalid_request"`,
+		},
+		{
+			name:      "challenge unknown",
+			challenge: "login-challenge-unknown",
+			status:    http.StatusNotFound,
+			contains:  `"error":"request_not_found"`,
+		},
+		{
+			name: "login described",
+			request: &models.LoginRequest{
+				Client:         &models.OAuth2Client{ClientID: testClient, ClientName: "Example App"},
+				RequestedScope: []string{"openid", "email"},
+				OidcContext:    &models.OpenIDConnectContext{LoginHint: "jdoe@example.com"},
+			},
+			status:   http.StatusOK,
+			contains: `"login_hint":"jdoe@example.com","connections":[{"name":"password"`,
+		},
+		{
+			name: "no prompt rejected",
+			request: &models.LoginRequest{
+				Client:     &models.OAuth2Client{ClientID: testClient},
+				RequestURL: stringPtr(hydraURL + "/oauth2/auth?prompt=none"),
+			},
+			status:     http.StatusOK,
+			contains:   `"redirect_to":"` + hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "login_required",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := c.challenge
+			if c.request != nil {
+				challenge = ts.hydra.NewLoginRequest(c.request)
+			}
+
+			resp, body := ts.get(t, "/api/v1/login?login_challenge="+challenge)
+
+			checkResponse(t, resp, body, c.status, "", c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+		})
+	}
+}
+
+func TestAPICompleteLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		body       interface{}
+		acrValues  []string
+		status     int
+		contains   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:      "accepted",
+			body:      apiLoginRequest{Email: "jdoe@example.com", Password: "secret", Remember: true},
+			status:    http.StatusOK,
+			contains:  `"redirect_to":"` + hydraURL + "/oauth2/auth?login_verifier=",
+			operation: hydratest.OpAcceptLoginRequest,
+		},
+		{
+			name:     "invalid credentials",
+			body:     apiLoginRequest{Email: "jdoe@example.com", Password: "wrong"},
+			status:   http.StatusUnauthorized,
+			contains: `"error":"invalid_credentials"`,
+		},
+		{
+			name:     "missing password",
+			body:     apiLoginRequest{Email: "jdoe@example.com"},
+			status:   http.StatusBadRequest,
+			contains: `"error":"invalid_input"`,
+		},
+		{
+			name:     "password kept as typed",
+			body:     apiLoginRequest{Email: "jdoe@example.com", Password: " secret "},
+			status:   http.StatusUnauthorized,
+			contains: `"error":"invalid_credentials"`,
+		},
+		{
+			name:     "connection not allowed",
+			body:     apiLoginRequest{Email: "jdoe@example.com", Password: "secret", Connection: "unknown"},
+			status:   http.StatusBadRequest,
+			contains: `"error":"invalid_connection"`,
+		},
+		{
+			name:      "step-up asked for",
+			body:      apiLoginRequest{Email: "jdoe@example.com", Password: "secret"},
+			acrValues: []string{provider.LevelMultiFactor.ACR()},
+			status:    http.StatusForbidden,
+			contains:  `"error":"step_up_required"`,
+		},
+		{
+			name:       "disabled account rejected",
+			body:       apiLoginRequest{Email: "jdoe@example.com", Password: "disabled"},
+			status:     http.StatusOK,
+			contains:   `"redirect_to":"` + hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectLoginRequest,
+			rejectWith: "access_denied",
+		},
+		{
+			name:     "malformed body",
+			body:     `{"login_challenge":`,
+			status:   http.StatusBadRequest,
+			contains: `"error":"invalid_request"`,
+		},
+		{
+			name:     "oversized body",
+			body:     `{"password":"` + strings.Repeat("a", maxRequestSize) + `"}`,
+			status:   http.StatusBadRequest,
+			contains: `"error":"invalid_request"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{
+				Client:      &models.OAuth2Client{ClientID: testClient},
+				OidcContext: &models.OpenIDConnectContext{AcrValues: c.acrValues},
+			})
+
+			body := c.body
+			if b, ok := body.(apiLoginRequest); ok {
+				b.Challenge = challenge
+				body = b
+			}
+
+			resp, respBody := ts.postJSON(t, "/api/v1/login", body)
+
+			checkResponse(t, resp, respBody, c.status, "", c.contains)
+			call := checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+
+			if c.operation != hydratest.OpAcceptLoginRequest {
+				return
+			}
+
+			accept, _ := call.Body.(*models.AcceptLoginRequest)
+			if accept == nil || *accept.Subject != testSubject || !accept.Remember {
+				t.Fatalf("accepted with %+v", call.Body)
+			}
+		})
+	}
+}
+
+func TestAPIIdentifyLogin(t *testing.T) {
+	cases := []struct {
+		name     string
+		email    string
+		status   int
+		contains string
+	}{
+		{
+			name:     "connection of the domain",
+			email:    "jdoe@corp.test",
+			status:   http.StatusOK,
+			contains: `"connection":{"name":"corp","title":"corp","federated":false}`,
+		},
+		{
+			name:     "federated connection of the domain",
+			email:    "jdoe@partner.test",
+			status:   http.StatusOK,
+			contains: `"connection":{"name":"partner","title":"partner","federated":true}`,
+		},
+		{
+			name:     "unknown domain",
+			email:    "jdoe@example.com",
+			status:   http.StatusBadRequest,
+			contains: `"error":"unknown_domain"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			partner, _ := federatedConnection(t, "partner", "partner.test")
+
+			ts := newTestService(t, Config{},
+				provider.Connection{Name: "corp", Domains: []string{"corp.test"}, Provider: stubProvider{}},
+				partner,
+			)
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{
+				ClientID: testClient,
+				Metadata: map[string]interface{}{metadataConnections: []interface{}{"corp", "partner"}},
+			}})
+
+			resp, body := ts.postJSON(t, "/api/v1/login/identify", apiIdentifyRequest{Challenge: challenge, Email: c.email})
+
+			checkResponse(t, resp, body, c.status, "", c.contains)
+			checkOutcome(t, ts.hydra, challenge, "", "")
+		})
+	}
+}
+
+func TestAPIFederateLogin(t *testing.T) {
+	cases := []struct {
+		name       string
+		connection string
+		handoff    bool
+		status     int
+		contains   string
+	}{
+		{
+			name:       "handed off",
+			connection: "partner",
+			handoff:    true,
+			status:     http.StatusOK,
+		},
+		{
+			name:       "connection not federated",
+			connection: "password",
+			status:     http.StatusBadRequest,
+			contains:   `"error":"invalid_connection"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			partner, issuer := federatedConnection(t, "partner")
+			ts := newTestService(t, Config{}, partner)
+
+			challenge := ts.hydra.NewLoginRequest(&models.LoginRequest{Client: &models.OAuth2Client{ClientID: testClient}})
+
+			resp, body := ts.postJSON(t, "/api/v1/login/federate", apiFederateRequest{Challenge: challenge, Connection: c.connection})
+
+			contains := c.contains
+			if c.handoff {
+				contains = `"redirect_to":"` + issuer.URL + "/authorize?"
+			}
+
+			checkResponse(t, resp, body, c.status, "", contains)
+			checkOutcome(t, ts.hydra, challenge, "", "")
+		})
+	}
+}
+
+func TestAPIBeginConsent(t *testing.T) {
+	cases := []struct {
+		name      string
+		remember  bool
+		challenge string
+		status    int
+		contains  string
+		operation string
+	}{
+		{
+			name:      "challenge unknown",
+			challenge: "consent-challenge-unknown",
+			status:    http.StatusNotFound,
+			contains:  `"error":"request_not_found"`,
+		},
+		{
+			name:     "consent described",
+			status:   http.StatusOK,
+			contains: `"subject":"` + testSubject + `","scope_groups":[`,
+		},
+		{
+			name:      "remembered consent accepted",
+			remember:  true,
+			status:    http.StatusOK,
+			contains:  `"redirect_to":"` + hydraURL + "/oauth2/auth?consent_verifier=",
+			operation: hydratest.OpAcceptConsentRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			client := &models.OAuth2Client{ClientID: testClient}
+			if c.remember {
+				ts.hydra.RememberConsent(testSubject, client, "openid", "email")
+			}
+
+			challenge := c.challenge
+			if challenge == "" {
+				challenge = ts.hydra.NewConsentRequest(newConsentRequest(client))
+			}
+
+			resp, body := ts.get(t, "/api/v1/consent?consent_challenge="+challenge)
+
+			checkResponse(t, resp, body, c.status, "", c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, "")
+		})
+	}
+}
+
+func TestAPICompleteConsent(t *testing.T) {
+	cases := []struct {
+		name       string
+		body       apiConsentRequest
+		contains   string
+		operation  string
+		rejectWith string
+	}{
+		{
+			name:      "accepted",
+			body:      apiConsentRequest{Action: actionAccept, GrantScope: []string{"openid", "email"}},
+			contains:  `"redirect_to":"` + hydraURL + "/oauth2/auth?consent_verifier=",
+			operation: hydratest.OpAcceptConsentRequest,
+		},
+		{
+			name:       "denied",
+			body:       apiConsentRequest{Action: actionDeny},
+			contains:   `"redirect_to":"` + hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectConsentRequest,
+			rejectWith: "access_denied",
+		},
+		{
+			name:       "unrequested scope rejected",
+			body:       apiConsentRequest{Action: actionAccept, GrantScope: []string{"openid", "phone"}},
+			contains:   `"redirect_to":"` + hydraURL + "/oauth2/fallbacks/error?",
+			operation:  hydratest.OpRejectConsentRequest,
+			rejectWith: "invalid_scope",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			challenge := ts.hydra.NewConsentRequest(newConsentRequest(&models.OAuth2Client{ClientID: testClient}))
+			c.body.Challenge = challenge
+
+			resp, body := ts.postJSON(t, "/api/v1/consent", c.body)
+
+			checkResponse(t, resp, body, http.StatusOK, "", c.contains)
+			checkOutcome(t, ts.hydra, challenge, c.operation, c.rejectWith)
+		})
+	}
+}
+
+func TestListApps(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   Config
+		signedIn bool
+		apps     bool
+		status   int
+		location string
+		contains string
+	}{
+		{
+			name:     "apps listed",
+			signedIn: true,
+			apps:     true,
+			status:   http.StatusOK,
+			contains: "<b>Example App</b>",
+		},
+		{
+			name:     "no apps shown",
+			signedIn: true,
+			status:   http.StatusOK,
+			contains: "You have not authorized any applications",
+		},
+		{
+			name:     "signed out shown",
+			apps:     true,
+			status:   http.StatusUnauthorized,
+			contains: "Please sign in to continue",
+		},
+		{
+			name:     "signed out sent to sign in",
+			config:   Config{AccountLoginURL: "https://app.test/login"},
+			status:   http.StatusFound,
+			location: "https://app.test/login",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, c.config)
+
+			if c.signedIn {
+				ts.signIn(t)
+			}
+
+			if c.apps {
+				client := &models.OAuth2Client{ClientID: testClient, ClientName: "Example App"}
+
+				// The consent of someone else is not listed.
+				ts.hydra.RememberConsent(testSubject, client, "openid")
+				ts.hydra.RememberConsent("user-2", client, "openid")
+			}
+
+			resp, body := ts.get(t, "/account/apps")
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+
+			if n := strings.Count(body, "Revoke access"); c.status == http.StatusOK && c.apps && n != 1 {
+				t.Fatalf("%d apps listed, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRevokeApp(t *testing.T) {
+	cases := []struct {
+		name     string
+		signedIn bool
+		client   string
+		status   int
+		location string
+		contains string
+	}{
+		{
+			name:     "revoked",
+			signedIn: true,
+			client:   testClient,
+			status:   http.StatusSeeOther,
+			location: "/account/apps",
+		},
+		{
+			name:     "client missing",
+			signedIn: true,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "signed out shown",
+			client:   testClient,
+			status:   http.StatusUnauthorized,
+			contains: "Please sign in to continue",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			if c.signedIn {
+				ts.signIn(t)
+			}
+
+			ts.hydra.RememberConsent(testSubject, &models.OAuth2Client{ClientID: testClient}, "openid")
+			ts.hydra.RememberConsent(testSubject, &models.OAuth2Client{ClientID: "other-app"}, "openid")
+
+			resp, body := ts.post(t, "/account/apps/revoke", url.Values{clientIDKey: {c.client}})
+
+			checkResponse(t, resp, body, c.status, c.location, c.contains)
+
+			call, revoked := ts.hydra.LastCall(hydratest.OpRevokeConsentSessions)
+			if revoked != (c.status == http.StatusSeeOther) {
+				t.Fatalf("consent sessions revoked = %t", revoked)
+			}
+
+			if !revoked {
+				return
+			}
+
+			if client, _ := call.Body.(*string); call.Subject != testSubject || client == nil || *client != testClient {
+				t.Fatalf("revoked with %+v", call)
+			}
+
+			// Only the consent to the revoked app is forgotten.
+			_, body = ts.get(t, "/account/apps")
+			if strings.Contains(body, `value="`+testClient+`"`) || !strings.Contains(body, `value="other-app"`) {
+				t.Fatalf("apps listed after the revocation: %s", body)
+			}
+		})
+	}
+}
+
+func TestShowSessions(t *testing.T) {
+	cases := []struct {
+		name     string
+		signedIn bool
+		status   int
+		contains string
+	}{
+		{
+			name:     "shown",
+			signedIn: true,
+			status:   http.StatusOK,
+			contains: `action="/account/sessions/revoke"`,
+		},
+		{
+			name:     "signed out shown",
+			status:   http.StatusUnauthorized,
+			contains: "Please sign in to continue",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			if c.signedIn {
+				ts.signIn(t)
+			}
+
+			resp, body := ts.get(t, "/account/sessions")
+
+			checkResponse(t, resp, body, c.status, "", c.contains)
+		})
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	cases := []struct {
+		name     string
+		signedIn bool
+		consents string
+		status   int
+		contains string
+	}{
+		{
+			name:     "signed out everywhere",
+			signedIn: true,
+			status:   http.StatusOK,
+			contains: "You have been signed out of all devices",
+		},
+		{
+			name:     "apps disconnected too",
+			signedIn: true,
+			consents: "true",
+			status:   http.StatusOK,
+			contains: "You have been signed out of all devices",
+		},
+		{
+			name:     "signed out shown",
+			status:   http.StatusUnauthorized,
+			contains: "Please sign in to continue",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{})
+
+			if c.signedIn {
+				ts.signIn(t)
+			}
+
+			resp, body := ts.post(t, "/account/sessions/revoke", url.Values{revokeConsentsKey: {c.consents}})
+
+			checkResponse(t, resp, body, c.status, "", c.contains)
+			checkRevoked(t, ts.hydra, c.signedIn, c.consents == "true")
+
+			if !c.signedIn {
+				return
+			}
+
+			if _, remembered := ts.hydra.RememberedLogin(); remembered {
+				t.Fatal("login still remembered")
+			}
+
+			resp, body = ts.get(t, "/account/sessions")
+			checkResponse(t, resp, body, http.StatusUnauthorized, "", "Please sign in to continue")
+		})
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	cases := []struct {
+		name       string
+		adminToken string
+		method     string
+		token      string
+		form       url.Values
+		status     int
+	}{
+		{
+			name:       "revoked",
+			adminToken: "admin-token",
+			method:     http.MethodPost,
+			token:      "admin-token",
+			form:       url.Values{subjectKey: {testSubject}},
+			status:     http.StatusNoContent,
+		},
+		{
+			name:       "revoked with consents",
+			adminToken: "admin-token",
+			method:     http.MethodPost,
+			token:      "admin-token",
+			form:       url.Values{subjectKey: {testSubject}, revokeConsentsKey: {"true"}},
+			status:     http.StatusNoContent,
+		},
+		{
+			name:       "token missing",
+			adminToken: "admin-token",
+			method:     http.MethodPost,
+			form:       url.Values{subjectKey: {testSubject}},
+			status:     http.StatusUnauthorized,
+		},
+		{
+			name:       "token wrong",
+			adminToken: "admin-token",
+			method:     http.MethodPost,
+			token:      "admin-token-2",
+			form:       url.Values{subjectKey: {testSubject}},
+			status:     http.StatusUnauthorized,
+		},
+		{
+			name:   "no token configured",
+			method: http.MethodPost,
+			form:   url.Values{subjectKey: {testSubject}},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "method not allowed",
+			adminToken: "admin-token",
+			method:     http.MethodGet,
+			token:      "admin-token",
+			status:     http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "subject missing",
+			adminToken: "admin-token",
+			method:     http.MethodPost,
+			token:      "admin-token",
+			form:       url.Values{},
+			status:     http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestService(t, Config{AdminToken: c.adminToken})
+			ts.hydra.RememberLogin(testSubject)
+
+			r := httptest.NewRequest(c.method, "/sessions/revoke", strings.NewReader(c.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if c.token != "" {
+				r.Header.Set("Authorization", "Bearer "+c.token)
+			}
+
+			w := httptest.NewRecorder()
+			ts.service.AdminHandler().ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("status = %d, want %d", w.Code, c.status)
+			}
+
+			if c.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatal("bearer token not asked for")
+			}
+
+			checkRevoked(t, ts.hydra, c.status == http.StatusNoContent, c.form.Get(revokeConsentsKey) == "true")
+		})
+	}
+}
+
+// checkRevoked checks whether the login sessions of the test subject
+// and, optionally, all of its consents were revoked.
+func checkRevoked(t *testing.T, hydra *hydratest.Admin, sessions, consents bool) {
+	t.Helper()
+
+	call, revoked := hydra.LastCall(hydratest.OpRevokeAuthenticationSession)
+	if revoked != sessions || (revoked && call.Subject != testSubject) {
+		t.Fatalf("login sessions revoked = %t with %+v, want %t", revoked, call, sessions)
+	}
+
+	call, revoked = hydra.LastCall(hydratest.OpRevokeConsentSessions)
+	if revoked != consents || (revoked && call.Subject != testSubject) {
+		t.Fatalf("consent sessions revoked = %t with %+v, want %t", revoked, call, consents)
+	}
+}
+
+// newConsentRequest asks for the openid and email scopes on behalf of the
+// test subject, with the login context as Hydra hands it back as JSON.
+func newConsentRequest(client *models.OAuth2Client) *models.ConsentRequest {
+	return &models.ConsentRequest{
+		Subject:        testSubject,
+		Client:         client,
+		RequestedScope: []string{"openid", "email"},
+		Context: map[string]interface{}{
+			traitsKey: map[string]interface{}{"email": "jdoe@example.com", "email_verified": true},
+		},
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mpraski/identity-provider/app/csrf"
+	"github.com/mpraski/identity-provider/app/session"
+	hydraAdmin "github.com/ory/hydra-client-go/client/admin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	subjectKey        = "subject"
+	revokeConsentsKey = "revoke_consents"
+)
+
+func (s *Service) showSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = s.renderer.Render(w, http.StatusOK, "sessions", csrf.WithToken(r, map[string]interface{}{}))
+}
+
+func (s *Service) revokeSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		subject  = session.Subject(r)
+		consents = strings.TrimSpace(r.PostFormValue(revokeConsentsKey)) == "true"
+	)
+
+	if subject == "" {
+		s.unauthenticated(w, r)
+		return
+	}
+
+	if err := s.revokeSubject(r.Context(), subject, consents, "self"); err != nil {
+		log.WithError(err).Error("failed to revoke sessions")
+
+		_ = s.renderer.Render(w, http.StatusInternalServerError, "error", map[string]interface{}{
+			"ErrorMessage": "Failed to sign out of all devices",
+		})
+
+		return
+	}
+
+	s.sessions.Clear(w)
+
+	_ = s.renderer.Render(w, http.StatusOK, "sessions", map[string]interface{}{
+		"SessionsMessage": "You have been signed out of all devices",
+	})
+}
+
+// AdminHandler revokes the sessions of any subject. It is meant
+// to be served on the internal observability & admin server only,
+// and requires the admin token to be presented as a bearer token.
+func (s *Service) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		var (
+			subject  = strings.TrimSpace(r.FormValue(subjectKey))
+			consents = strings.TrimSpace(r.FormValue(revokeConsentsKey)) == "true"
+		)
+
+		if subject == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.revokeSubject(r.Context(), subject, consents, "admin"); err != nil {
+			log.WithError(err).Error("failed to revoke sessions")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Service) adminAuthorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return s.config.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// revokeSubject revokes all login sessions of the subject and,
+// optionally, all of its consents along with the issued tokens.
+func (s *Service) revokeSubject(ctx context.Context, subject string, consents bool, initiator string) error {
+	params := hydraAdmin.NewRevokeAuthenticationSessionParams()
+	params.WithContext(ctx)
+	params.SetSubject(subject)
+
+	if _, err := s.hydra.RevokeAuthenticationSession(params); err != nil {
+		return fmt.Errorf("failed to revoke authentication sessions: %w", err)
+	}
+
+	if consents {
+		all := true
+
+		params := hydraAdmin.NewRevokeConsentSessionsParams()
+		params.WithContext(ctx)
+		params.SetSubject(subject)
+		params.SetAll(&all)
+
+		if _, err := s.hydra.RevokeConsentSessions(params); err != nil {
+			return fmt.Errorf("failed to revoke consent sessions: %w", err)
+		}
+	}
+
+	s.sessions.Revoke(subject)
+
+	audit("sessions_revoked", log.Fields{
+		"subject":          subject,
+		"initiator":        initiator,
+		"consents_revoked": consents,
+	})
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Flow is a login which continues elsewhere, such as at an upstream
+// identity provider, kept in a signed cookie until the user is back.
+// If the user comes back with a cross-site POST, as with SAML, the
+// cookie has to allow it, which browsers only accept over HTTPS.
+type Flow struct {
+	Challenge     string            `json:"challenge"`
+	Connection    string            `json:"connection"`
+	State         string            `json:"state"`
+	Saved         map[string]string `json:"saved,omitempty"`
+	Expires       int64             `json:"expires"`
+	CrossSitePost bool              `json:"-"`
+}
+
+const (
+	flowCookieName = "login_flow"
+	flowCookiePath = "/authentication/login"
+	flowMaxAge     = 10 * time.Minute
+	// flowContext keeps flow signatures from being valid for sessions.
+	flowContext = "flow:"
+)
+
+var (
+	ErrFlowInvalid = errors.New("login flow is invalid")
+	ErrFlowExpired = errors.New("login flow has expired")
+)
+
+func (s *Store) SetFlow(w http.ResponseWriter, flow *Flow) error {
+	flow.Expires = time.Now().Add(flowMaxAge).Unix()
+
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+
+	cookie := &http.Cookie{
+		Name:     flowCookieName,
+		Value:    b64encode(payload) + "." + b64encode(s.sign(flowContext+string(payload))),
+		Path:     flowCookiePath,
+		MaxAge:   int(flowMaxAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if flow.CrossSitePost {
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	}
+
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// TakeFlow returns the flow and clears it, so that it can only be used once.
+func (s *Store) TakeFlow(w http.ResponseWriter, r *http.Request) (*Flow, error) {
+	cookie, err := r.Cookie(flowCookieName)
+	if err != nil {
+		return nil, ErrFlowInvalid
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     flowCookieName,
+		Path:     flowCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrFlowInvalid
+	}
+
+	payload := b64decode(parts[0])
+
+	if !hmac.Equal(s.sign(flowContext+string(payload)), b64decode(parts[1])) {
+		return nil, ErrFlowInvalid
+	}
+
+	var flow Flow
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, ErrFlowInvalid
+	}
+
+	if time.Now().After(time.Unix(flow.Expires, 0)) {
+		return nil, ErrFlowExpired
+	}
+
+	return &flow, nil
+}
@@ -0,0 +1,268 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type (
+	// Store keeps the authenticated subject in a signed cookie,
+	// so that the self-service pages know who the user is. The
+	// cookie is only sent over HTTPS, unless the store is insecure.
+	Store struct {
+		key    []byte
+		maxAge time.Duration
+		secure bool
+
+		mu      sync.Mutex
+		revoked map[string]time.Time
+	}
+
+	Session struct {
+		Subject string
+		// Connection is the name of the connection the subject
+		// authenticated with, if known.
+		Connection string
+		// AuthTime is when the subject authenticated, zero if unknown.
+		AuthTime time.Time
+		// ACR and AMR describe how the subject authenticated.
+		ACR string
+		AMR []string
+	}
+
+	key int
+)
+
+const (
+	cookieName = "session"
+	keyLength  = 32
+)
+
+var (
+	ErrSessionInvalid = errors.New("session is invalid")
+	ErrSessionExpired = errors.New("session has expired")
+	ErrSessionRevoked = errors.New("session was revoked")
+)
+
+var subjectKey key = 1
+
+func NewStore(secret []byte, maxAge time.Duration, secure bool) *Store {
+	return &Store{key: secret, maxAge: maxAge, secure: secure, revoked: make(map[string]time.Time)}
+}
+
+// GenerateKey returns a random key, for when none is configured.
+func GenerateKey() ([]byte, error) {
+	k := make([]byte, keyLength)
+
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	return k, nil
+}
+
+// Subject returns the subject of the session established by
+// Protect, or an empty string if the request went without it.
+func Subject(r *http.Request) string {
+	subject, _ := r.Context().Value(subjectKey).(string)
+	return subject
+}
+
+func (s *Store) Set(w http.ResponseWriter, session *Session) {
+	var authTime int64
+	if !session.AuthTime.IsZero() {
+		authTime = session.AuthTime.Unix()
+	}
+
+	var (
+		issued  = time.Now()
+		expires = issued.Add(s.maxAge)
+		payload = strings.Join([]string{
+			session.Subject,
+			url.QueryEscape(session.Connection),
+			session.ACR,
+			strings.Join(session.AMR, ","),
+			strconv.FormatInt(authTime, 10),
+			strconv.FormatInt(issued.UnixNano(), 10),
+			strconv.FormatInt(expires.Unix(), 10),
+		}, "|")
+		value = b64encode([]byte(payload)) + "." + b64encode(s.sign(payload))
+	)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Store) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Store) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrSessionInvalid
+	}
+
+	var (
+		payload   = string(b64decode(parts[0]))
+		signature = b64decode(parts[1])
+	)
+
+	if !hmac.Equal(s.sign(payload), signature) {
+		return nil, ErrSessionInvalid
+	}
+
+	// The subject itself may contain the separator,
+	// so the other fields are split off from the end.
+	fields := strings.Split(payload, "|")
+	if len(fields) < 7 {
+		return nil, ErrSessionInvalid
+	}
+
+	n := len(fields)
+
+	authTime, err := strconv.ParseInt(fields[n-3], 10, 64)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	issued, err := strconv.ParseInt(fields[n-2], 10, 64)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	expires, err := strconv.ParseInt(fields[n-1], 10, 64)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	if time.Now().After(time.Unix(expires, 0)) {
+		return nil, ErrSessionExpired
+	}
+
+	subject := strings.Join(fields[:n-6], "|")
+
+	if s.isRevoked(subject, time.Unix(0, issued)) {
+		return nil, ErrSessionRevoked
+	}
+
+	var amr []string
+	if fields[n-4] != "" {
+		amr = strings.Split(fields[n-4], ",")
+	}
+
+	connection, err := url.QueryUnescape(fields[n-6])
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	sess := &Session{
+		Subject:    subject,
+		Connection: connection,
+		ACR:        fields[n-5],
+		AMR:        amr,
+	}
+
+	// A session without an authentication time keeps it zero,
+	// for it to be told apart from one long ago.
+	if authTime != 0 {
+		sess.AuthTime = time.Unix(authTime, 0)
+	}
+
+	return sess, nil
+}
+
+// Revoke invalidates the sessions of the subject issued until now. The
+// cookies are stateless, so the revocations are kept in memory until the
+// sessions would have expired anyway. With several replicas, a revoked
+// session stays usable on the other ones until it expires.
+func (s *Store) Revoke(subject string) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, at := range s.revoked {
+		if now.Sub(at) > s.maxAge {
+			delete(s.revoked, k)
+		}
+	}
+
+	s.revoked[subject] = now
+}
+
+func (s *Store) isRevoked(subject string, issued time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.revoked[subject]
+
+	return ok && !issued.After(at)
+}
+
+// Protect only lets requests with a valid session through,
+// calling unauthenticated for the rest.
+func (s *Store) Protect(h httprouter.Handle, unauthenticated http.HandlerFunc) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		session, err := s.Get(r)
+		if err != nil {
+			unauthenticated(w, r)
+			return
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), subjectKey, session.Subject)), p)
+	}
+}
+
+func (s *Store) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+func b64encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func b64decode(data string) []byte {
+	decoded, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil
+	}
+
+	return decoded
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	authTime := time.Unix(time.Now().Add(-time.Minute).Unix(), 0)
+
+	cases := []struct {
+		name    string
+		session *Session
+		revoke  func(s *Store)
+		maxAge  time.Duration
+		err     error
+	}{
+		{
+			name: "kept",
+			session: &Session{
+				Subject:    "jdoe|1",
+				Connection: "corp|sso",
+				AuthTime:   authTime,
+				ACR:        "2",
+				AMR:        []string{"pwd", "otp"},
+			},
+		},
+		{
+			name:    "authentication time unknown",
+			session: &Session{Subject: "jdoe"},
+		},
+		{
+			name:    "expired",
+			session: &Session{Subject: "jdoe", AuthTime: authTime},
+			maxAge:  -time.Second,
+			err:     ErrSessionExpired,
+		},
+		{
+			name:    "revoked",
+			session: &Session{Subject: "jdoe", AuthTime: authTime},
+			revoke:  func(s *Store) { s.Revoke("jdoe") },
+			err:     ErrSessionRevoked,
+		},
+		{
+			name:    "other subject revoked",
+			session: &Session{Subject: "jdoe", AuthTime: authTime},
+			revoke:  func(s *Store) { s.Revoke("other") },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			maxAge := c.maxAge
+			if maxAge == 0 {
+				maxAge = time.Hour
+			}
+
+			s := NewStore([]byte("secret"), maxAge, false)
+			r := issue(s, c.session)
+
+			if c.revoke != nil {
+				c.revoke(s)
+			}
+
+			got, err := s.Get(r)
+
+			switch {
+			case !errors.Is(err, c.err) || (c.err == nil && err != nil):
+				t.Fatalf("reading the session failed with %v, want %v", err, c.err)
+			case err == nil && !reflect.DeepEqual(got, c.session):
+				t.Fatalf("session = %+v, want %+v", got, c.session)
+			}
+		})
+	}
+}
+
+// TestStoreIssuedAfterRevocation checks that signing in again right
+// after the sessions were revoked is not revoked as well.
+func TestStoreIssuedAfterRevocation(t *testing.T) {
+	s := NewStore([]byte("secret"), time.Hour, false)
+
+	revoked := issue(s, &Session{Subject: "jdoe"})
+	s.Revoke("jdoe")
+	issued := issue(s, &Session{Subject: "jdoe"})
+
+	if _, err := s.Get(revoked); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("session issued before the revocation read with %v", err)
+	}
+
+	if _, err := s.Get(issued); err != nil {
+		t.Fatalf("session issued after the revocation read with %v", err)
+	}
+}
+
+func TestStoreTampered(t *testing.T) {
+	s := NewStore([]byte("secret"), time.Hour, false)
+	r := issue(NewStore([]byte("other"), time.Hour, false), &Session{Subject: "jdoe"})
+
+	if _, err := s.Get(r); !errors.Is(err, ErrSessionInvalid) {
+		t.Fatalf("session signed with another key read with %v", err)
+	}
+}
+
+// issue returns a request carrying the session cookie set by the store.
+func issue(s *Store, session *Session) *http.Request {
+	w := httptest.NewRecorder()
+	s.Set(w, session)
+
+	r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	return r
+}
@@ -0,0 +1,107 @@
+package template
+
+import (
+	"strings"
+)
+
+// Messages are the localized texts of the login page.
+type Messages struct {
+	Title        string
+	EmailAddress string
+	Password     string
+	RememberMe   string
+	SignIn       string
+
+	// Reasons the user could not sign in. They must not tell
+	// whether an account with the given email exists.
+	InvalidCredentials string
+	InvalidInput       string
+	StepUpRequired     string
+	Unavailable        string
+	SignInFailed       string
+	ChooseConnection   string
+	UnknownDomain      string
+	EnterEmail         string
+
+	// Continue moves on from the email to the rest of the form.
+	Continue string
+}
+
+const DefaultLocale = "en"
+
+var messages = map[string]*Messages{
+	"en": {
+		Title:        "Please sign in",
+		EmailAddress: "Email address",
+		Password:     "Password",
+		RememberMe:   "Remember me",
+		SignIn:       "Sign in",
+
+		InvalidCredentials: "Invalid email or password",
+		InvalidInput:       "Please enter your email address and password",
+		StepUpRequired:     "This application requires a stronger way of signing in, please choose another one",
+		Unavailable:        "Signing in is temporarily unavailable, please try again shortly",
+		SignInFailed:       "Failed to sign in, please try again",
+		ChooseConnection:   "Please choose how to sign in",
+		UnknownDomain:      "Signing in with this email address is not supported",
+		EnterEmail:         "Please enter your email address",
+
+		Continue: "Continue",
+	},
+	"pl": {
+		Title:        "Zaloguj się",
+		EmailAddress: "Adres e-mail",
+		Password:     "Hasło",
+		RememberMe:   "Zapamiętaj mnie",
+		SignIn:       "Zaloguj",
+
+		InvalidCredentials: "Nieprawidłowy adres e-mail lub hasło",
+		InvalidInput:       "Podaj adres e-mail i hasło",
+		StepUpRequired:     "Ta aplikacja wymaga silniejszego sposobu logowania, wybierz inny",
+		Unavailable:        "Logowanie jest chwilowo niedostępne, spróbuj ponownie za chwilę",
+		SignInFailed:       "Nie udało się zalogować, spróbuj ponownie",
+		ChooseConnection:   "Wybierz sposób logowania",
+		UnknownDomain:      "Logowanie tym adresem e-mail nie jest obsługiwane",
+		EnterEmail:         "Podaj adres e-mail",
+
+		Continue: "Dalej",
+	},
+	"de": {
+		Title:        "Bitte melden Sie sich an",
+		EmailAddress: "E-Mail-Adresse",
+		Password:     "Passwort",
+		RememberMe:   "Angemeldet bleiben",
+		SignIn:       "Anmelden",
+
+		InvalidCredentials: "Ungültige E-Mail-Adresse oder ungültiges Passwort",
+		InvalidInput:       "Bitte geben Sie Ihre E-Mail-Adresse und Ihr Passwort ein",
+		StepUpRequired:     "Diese Anwendung erfordert eine sicherere Anmeldung, bitte wählen Sie eine andere",
+		Unavailable:        "Die Anmeldung ist vorübergehend nicht verfügbar, bitte versuchen Sie es gleich erneut",
+		SignInFailed:       "Die Anmeldung ist fehlgeschlagen, bitte versuchen Sie es erneut",
+		ChooseConnection:   "Bitte wählen Sie aus, wie Sie sich anmelden möchten",
+		UnknownDomain:      "Die Anmeldung mit dieser E-Mail-Adresse wird nicht unterstützt",
+		EnterEmail:         "Bitte geben Sie Ihre E-Mail-Adresse ein",
+
+		Continue: "Weiter",
+	},
+}
+
+// Localize picks the first supported locale out of the preferred
+// ones, given as BCP 47 tags, and returns it along with its messages.
+func Localize(preferred []string) (string, *Messages) {
+	for _, tag := range preferred {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if m, ok := messages[tag]; ok {
+			return tag, m
+		}
+
+		if i := strings.IndexAny(tag, "-_"); i > 0 {
+			if m, ok := messages[tag[:i]]; ok {
+				return tag[:i], m
+			}
+		}
+	}
+
+	return DefaultLocale, messages[DefaultLocale]
+}
@@ -1,8 +1,8 @@
 package template
 
 import (
-	"embed"
 	"io"
+	"io/fs"
 
 	"github.com/unrolled/render"
 )
@@ -15,12 +15,12 @@ type (
 	Params = map[string]interface{}
 )
 
-func NewRenderer(files embed.FS) *Renderer {
+func NewRenderer(files fs.FS) *Renderer {
 	return &Renderer{
 		render: render.New(render.Options{
 			Layout:     "layout",
 			Directory:  "templates",
-			FileSystem: &render.EmbedFileSystem{FS: files},
+			FileSystem: render.FS(files),
 			Extensions: []string{".tmpl"},
 			// Other
 			RenderPartialsWithoutPrefix: true,
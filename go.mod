@@ -3,12 +3,15 @@ module github.com/mpraski/identity-provider
 go 1.17
 
 require (
+	github.com/beevik/etree v1.1.0
 	github.com/google/uuid v1.1.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/ory/hydra-client-go v1.10.6
+	github.com/russellhaering/goxmldsig v1.4.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/unrolled/render v1.4.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -27,6 +30,7 @@ require (
 	github.com/go-openapi/swag v0.19.15 // indirect
 	github.com/go-openapi/validate v0.20.2 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/jonboulle/clockwork v0.2.2 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.6 // indirect
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
@@ -36,5 +40,4 @@ require (
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777 // indirect
 	golang.org/x/sys v0.0.0-20210525143221-35b2ab0089ea // indirect
 	golang.org/x/text v0.3.5 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -11,9 +11,15 @@ import (
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/mpraski/identity-provider/app/catalog"
+	"github.com/mpraski/identity-provider/app/claims"
+	"github.com/mpraski/identity-provider/app/connection"
 	"github.com/mpraski/identity-provider/app/gateway/identities"
+	"github.com/mpraski/identity-provider/app/gateway/oidc"
+	"github.com/mpraski/identity-provider/app/gateway/saml"
 	"github.com/mpraski/identity-provider/app/provider"
 	"github.com/mpraski/identity-provider/app/service"
+	"github.com/mpraski/identity-provider/app/session"
 	"github.com/mpraski/identity-provider/app/template"
 	hydra "github.com/ory/hydra-client-go/client"
 	log "github.com/sirupsen/logrus"
@@ -33,9 +39,56 @@ type input struct {
 	Observability struct {
 		Address string `default:":9090"`
 	}
+	Admin struct {
+		// Token is required as a bearer token by the admin endpoints,
+		// which are not served at all unless it is set.
+		Token string
+	}
 	IdentityManager struct {
 		BaseURL string `required:"true" split_words:"true"`
+		// Auth is one of: none, bearer, mtls, hmac
+		Auth            string        `default:"none"`
+		BearerToken     string        `split_words:"true"`
+		ClientCertFile  string        `split_words:"true"`
+		ClientKeyFile   string        `split_words:"true"`
+		CAFile          string        `split_words:"true"`
+		HMACKeyID       string        `split_words:"true"`
+		HMACSecret      string        `split_words:"true"`
+		Timeout         time.Duration `default:"15s"`
+		RetryAttempts   int           `split_words:"true" default:"3"`
+		RetryBackoff    time.Duration `split_words:"true" default:"100ms"`
+		RetryMaxDelay   time.Duration `split_words:"true" default:"1s"`
+		BreakerFailures int           `split_words:"true" default:"5"`
+		BreakerCooldown time.Duration `split_words:"true" default:"30s"`
 	} `split_words:"true"`
+	Claims struct {
+		RulesFile string `split_words:"true"`
+	}
+	Catalog struct {
+		File string
+	}
+	Connections struct {
+		File           string
+		DefaultName    string   `split_words:"true" default:"password"`
+		DefaultTitle   string   `split_words:"true" default:"Email and password"`
+		DefaultDomains []string `split_words:"true"`
+		CallbackURL    string   `split_words:"true"`
+	}
+	Session struct {
+		Secret string
+		MaxAge time.Duration `split_words:"true" default:"24h"`
+		// Insecure lets the session cookie be sent over plain
+		// HTTP, which is only meant for local development.
+		Insecure bool
+	}
+	Account struct {
+		LoginURL string `split_words:"true"`
+	}
+	Consent struct {
+		MandatoryScopes []string      `split_words:"true" default:"openid"`
+		RememberFor     time.Duration `split_words:"true" default:"720h"`
+		TrustedClients  []string      `split_words:"true"`
+	}
 }
 
 //go:embed templates/*.tmpl
@@ -64,21 +117,70 @@ func main() {
 		log.Fatalf("failed to parse hydra base URL: %v", err)
 	}
 
+	var claimRules claims.Rules
+	if i.Claims.RulesFile != "" {
+		if claimRules, err = claims.Load(i.Claims.RulesFile); err != nil {
+			log.Fatalf("failed to load claim rules: %v", err)
+		}
+	}
+
+	var consentCatalog catalog.Catalog
+	if i.Catalog.File != "" {
+		c, errs := catalog.Load(i.Catalog.File)
+		if errs != nil {
+			log.Fatalf("failed to load catalog: %v", errs)
+		}
+
+		consentCatalog = *c
+	}
+
+	sessionKey := []byte(i.Session.Secret)
+	if len(sessionKey) == 0 {
+		log.Warn("no session secret configured, sessions will not survive restarts")
+
+		if sessionKey, err = session.GenerateKey(); err != nil {
+			log.Fatalf("failed to generate session key: %v", err)
+		}
+	}
+
+	client, err := newIdentityManager(&i, &connection.IdentityManager{
+		BaseURL:        i.IdentityManager.BaseURL,
+		Auth:           i.IdentityManager.Auth,
+		BearerToken:    i.IdentityManager.BearerToken,
+		ClientCertFile: i.IdentityManager.ClientCertFile,
+		ClientKeyFile:  i.IdentityManager.ClientKeyFile,
+		CAFile:         i.IdentityManager.CAFile,
+		HMACKeyID:      i.IdentityManager.HMACKeyID,
+		HMACSecret:     i.IdentityManager.HMACSecret,
+	})
+	if err != nil {
+		log.Fatalf("failed to create identity manager client: %v", err)
+	}
+
 	var (
-		done     = make(chan bool)
-		quit     = make(chan os.Signal, 1)
-		renderer = template.NewRenderer(embeds)
-		identity = provider.NewIdentityProvider(identities.New(i.IdentityManager.BaseURL))
-		router   = service.New(renderer, identity, hydra.NewHTTPClientWithConfig(nil,
+		done        = make(chan bool)
+		quit        = make(chan os.Signal, 1)
+		renderer    = template.NewRenderer(embeds)
+		connections = newConnections(&i, client)
+		svc         = service.New(renderer, connections, hydra.NewHTTPClientWithConfig(nil,
 			&hydra.TransportConfig{
 				Schemes:  []string{hydraBaseURL.Scheme},
 				Host:     hydraBaseURL.Host,
 				BasePath: hydraBaseURL.Path,
 			},
-		).Admin).Router()
+		).Admin, session.NewStore(sessionKey, i.Session.MaxAge, !i.Session.Insecure), service.Config{
+			ClaimRules:         claimRules,
+			MandatoryScopes:    i.Consent.MandatoryScopes,
+			Catalog:            consentCatalog,
+			ConsentRememberFor: i.Consent.RememberFor,
+			TrustedClients:     i.Consent.TrustedClients,
+			AccountLoginURL:    i.Account.LoginURL,
+			AdminToken:         i.Admin.Token,
+			CallbackURL:        i.Connections.CallbackURL,
+		})
 	)
 
-	observability := newObservabilityServer(&i)
+	observability := newObservabilityServer(&i, svc.AdminHandler())
 
 	go func() {
 		log.Println("starting observability server at", i.Observability.Address)
@@ -93,7 +195,7 @@ func main() {
 		ReadTimeout:  i.Server.ReadTimeout,
 		WriteTimeout: i.Server.WriteTimeout,
 		IdleTimeout:  i.Server.IdleTimeout,
-		Handler:      router,
+		Handler:      svc.Router(),
 	}
 
 	signal.Notify(quit, os.Interrupt)
@@ -131,6 +233,174 @@ func main() {
 	log.Println("server stopped")
 }
 
+// newConnections registers the default connection, backed by the
+// identity manager, followed by the ones listed in the connections file.
+func newConnections(cfg *input, client *identities.Client) *provider.Registry {
+	connections := provider.NewRegistry()
+
+	if err := connections.Register(provider.Connection{
+		Name:     cfg.Connections.DefaultName,
+		Title:    cfg.Connections.DefaultTitle,
+		Domains:  cfg.Connections.DefaultDomains,
+		Provider: provider.NewIdentityProvider(client),
+	}); err != nil {
+		log.Fatalf("failed to register default connection: %v", err)
+	}
+
+	if cfg.Connections.File == "" {
+		return connections
+	}
+
+	c, err := connection.Load(cfg.Connections.File)
+	if err != nil {
+		log.Fatalf("failed to load connections: %v", err)
+	}
+
+	for i := range c.Connections {
+		var (
+			conn = &c.Connections[i]
+			p    provider.Provider
+		)
+
+		switch conn.Type {
+		case connection.TypeIdentityManager:
+			client, err := newIdentityManager(cfg, conn.IdentityManager)
+			if err != nil {
+				log.Fatalf("failed to create identity manager client for connection %s: %v", conn.Name, err)
+			}
+
+			p = provider.NewIdentityProvider(client)
+		case connection.TypeOIDC:
+			p = newOIDCProvider(conn)
+		case connection.TypeSAML:
+			p = newSAMLProvider(conn)
+		}
+
+		if _, ok := p.(provider.Federated); ok {
+			checkCallbackURL(cfg.Connections.CallbackURL, conn.Name)
+		}
+
+		if err := connections.Register(provider.Connection{
+			Name:     conn.Name,
+			Title:    conn.Title,
+			Domains:  conn.Domains,
+			Provider: p,
+		}); err != nil {
+			log.Fatalf("failed to register connection: %v", err)
+		}
+	}
+
+	return connections
+}
+
+// checkCallbackURL makes sure federated connections have an absolute
+// callback URL to send users back to.
+func checkCallbackURL(callbackURL, connection string) {
+	if callbackURL == "" {
+		log.Fatalf("connection %s is federated but no callback URL is configured", connection)
+	}
+
+	if u, err := url.Parse(callbackURL); err != nil || !u.IsAbs() || u.Host == "" {
+		log.Fatalf("failed to parse callback URL %q for connection %s", callbackURL, connection)
+	}
+}
+
+func newOIDCProvider(conn *connection.Connection) *provider.OIDCProvider {
+	var opts []oidc.Option
+	if len(conn.OIDC.Scopes) != 0 {
+		opts = append(opts, oidc.WithScopes(conn.OIDC.Scopes...))
+	}
+
+	client, err := oidc.New(conn.OIDC.Issuer, conn.OIDC.ClientID, conn.OIDC.ClientSecret, opts...)
+	if err != nil {
+		log.Fatalf("failed to create OIDC client for connection %s: %v", conn.Name, err)
+	}
+
+	prefix := conn.Name + ":"
+	if conn.OIDC.SubjectPrefix != nil {
+		prefix = *conn.OIDC.SubjectPrefix
+	}
+
+	return provider.NewOIDCProvider(client, prefix)
+}
+
+func newSAMLProvider(conn *connection.Connection) *provider.SAMLProvider {
+	metadata, err := os.ReadFile(conn.SAML.IdPMetadataFile)
+	if err != nil {
+		log.Fatalf("failed to read identity provider metadata for connection %s: %v", conn.Name, err)
+	}
+
+	idp, err := saml.ParseMetadata(metadata)
+	if err != nil {
+		log.Fatalf("failed to parse identity provider metadata for connection %s: %v", conn.Name, err)
+	}
+
+	sp, err := saml.New(conn.SAML.EntityID, conn.SAML.ACSURL, idp)
+	if err != nil {
+		log.Fatalf("failed to create SAML service provider for connection %s: %v", conn.Name, err)
+	}
+
+	attributes := provider.DefaultAttributes
+	if a := conn.SAML.Attributes; a != nil {
+		for _, o := range []struct {
+			to   *string
+			from string
+		}{
+			{&attributes.Subject, a.Subject},
+			{&attributes.Email, a.Email},
+			{&attributes.Name, a.Name},
+			{&attributes.GivenName, a.GivenName},
+			{&attributes.FamilyName, a.FamilyName},
+			{&attributes.Groups, a.Groups},
+		} {
+			if o.from != "" {
+				*o.to = o.from
+			}
+		}
+	}
+
+	prefix := conn.Name + ":"
+	if conn.SAML.SubjectPrefix != nil {
+		prefix = *conn.SAML.SubjectPrefix
+	}
+
+	return provider.NewSAMLProvider(sp, attributes, prefix)
+}
+
+// newIdentityManager creates a client of the identity manager,
+// retrying and tripping the circuit breaker as configured.
+func newIdentityManager(cfg *input, m *connection.IdentityManager) (*identities.Client, error) {
+	return identities.New(m.BaseURL, append(
+		identityManagerAuth(m),
+		identities.WithTimeout(cfg.IdentityManager.Timeout),
+		identities.WithRetry(
+			cfg.IdentityManager.RetryAttempts,
+			cfg.IdentityManager.RetryBackoff,
+			cfg.IdentityManager.RetryMaxDelay,
+		),
+		identities.WithCircuitBreaker(
+			cfg.IdentityManager.BreakerFailures,
+			cfg.IdentityManager.BreakerCooldown,
+		),
+	)...)
+}
+
+func identityManagerAuth(m *connection.IdentityManager) []identities.Option {
+	switch m.Auth {
+	case "none", "":
+		return nil
+	case "bearer":
+		return []identities.Option{identities.WithBearerToken(m.BearerToken)}
+	case "mtls":
+		return []identities.Option{identities.WithClientCertificate(m.ClientCertFile, m.ClientKeyFile, m.CAFile)}
+	case "hmac":
+		return []identities.Option{identities.WithHMACSigning(m.HMACKeyID, []byte(m.HMACSecret))}
+	default:
+		log.Fatalf("unknown identity manager auth: %s", m.Auth)
+		return nil
+	}
+}
+
 func healthz() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if atomic.LoadInt32(&healthy) == 1 {
@@ -141,10 +411,16 @@ func healthz() http.Handler {
 	})
 }
 
-func newObservabilityServer(cfg *input) *http.Server {
+func newObservabilityServer(cfg *input, admin http.Handler) *http.Server {
 	router := http.NewServeMux()
 	router.Handle("/healthz", healthz())
 
+	if cfg.Admin.Token != "" {
+		router.Handle("/admin/sessions/revoke", admin)
+	} else {
+		log.Warn("no admin token configured, the admin endpoints are disabled")
+	}
+
 	return &http.Server{
 		Addr:         cfg.Observability.Address,
 		ReadTimeout:  cfg.Server.ReadTimeout,